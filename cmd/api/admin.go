@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"social/internal/store"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ListRoles godoc
+//
+//	@Summary		Lists roles
+//	@Description	Lists every role with its permissions
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	[]store.Role
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/roles [get]
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.store.Roles.GetAll(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, roles); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type CreateRolePayload struct {
+	Name        string   `json:"name" validate:"required,max=255"`
+	Description string   `json:"description" validate:"max=1000"`
+	Level       int      `json:"level" validate:"min=0"`
+	Permissions []string `json:"permissions" validate:"dive,max=100"`
+}
+
+// CreateRole godoc
+//
+//	@Summary		Creates a role
+//	@Description	Creates a role with a set of permissions
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateRolePayload	true	"Role payload"
+//	@Success		201		{object}	store.Role
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		409		{object}	error	"Role name taken"
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/roles [post]
+func (app *application) createRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateRolePayload
+	err := readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = Validate.Struct(payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	role := &store.Role{
+		Name:        payload.Name,
+		Description: payload.Description,
+		Level:       payload.Level,
+		Permissions: payload.Permissions,
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+
+	err = app.store.Roles.Create(r.Context(), role)
+	if err != nil {
+		switch err {
+		case store.ErrConflict:
+			app.conflictResponse(w, r, err)
+		case store.ErrUnknownPermission:
+			app.badRequestError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.auditRoleChange(r, store.AuditRoleCreated, role)
+
+	if err := app.jsonResponse(w, http.StatusCreated, role); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type UpdateRolePayload struct {
+	Name        *string   `json:"name" validate:"omitempty,max=255"`
+	Description *string   `json:"description" validate:"omitempty,max=1000"`
+	Level       *int      `json:"level" validate:"omitempty,min=0"`
+	Permissions *[]string `json:"permissions" validate:"omitempty,dive,max=100"`
+}
+
+// UpdateRole godoc
+//
+//	@Summary		Updates a role
+//	@Description	Updates a role, permissions replace the current set when given
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			roleID	path		int					true	"Role ID"
+//	@Param			payload	body		UpdateRolePayload	true	"Role payload"
+//	@Success		200		{object}	store.Role
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error	"Role name taken"
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/roles/{roleID} [patch]
+func (app *application) updateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	roleID, err := strconv.ParseInt(chi.URLParam(r, "roleID"), 10, 64)
+	if err != nil || roleID < 1 {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	var payload UpdateRolePayload
+	err = readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = Validate.Struct(payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	role, err := app.store.Roles.GetByID(ctx, roleID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if payload.Name != nil {
+		role.Name = *payload.Name
+	}
+	if payload.Description != nil {
+		role.Description = *payload.Description
+	}
+	if payload.Level != nil {
+		role.Level = *payload.Level
+	}
+	if payload.Permissions != nil {
+		role.Permissions = *payload.Permissions
+	}
+
+	err = app.store.Roles.Update(ctx, role)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundError(w, r, err)
+		case store.ErrConflict:
+			app.conflictResponse(w, r, err)
+		case store.ErrUnknownPermission:
+			app.badRequestError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.auditRoleChange(r, store.AuditRoleUpdated, role)
+	app.invalidateRoleCache(ctx, role.ID)
+
+	if err := app.jsonResponse(w, http.StatusOK, role); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type AssignRolePayload struct {
+	RoleID int64 `json:"role_id" validate:"required,min=1"`
+}
+
+// AssignRole godoc
+//
+//	@Summary		Changes the role of a user
+//	@Description	Assigns a role to a user, it takes effect on the user's next request
+//	@Tags			admin
+//	@Accept			json
+//	@Param			userID	path		int					true	"User ID"
+//	@Param			payload	body		AssignRolePayload	true	"Role"
+//	@Success		204		{string}	string				"Role assigned"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/role [put]
+func (app *application) assignRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil || userID < 1 {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	var payload AssignRolePayload
+	err = readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = Validate.Struct(payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	previousRoleID, err := app.store.Roles.AssignToUser(ctx, userID, payload.RoleID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, store.AuditEvent{
+		ActorID:    getUserFromContext(r).ID,
+		Action:     store.AuditRoleAssigned,
+		TargetType: "user",
+		TargetID:   userID,
+		Metadata:   map[string]any{"role_id": payload.RoleID, "previous_role_id": previousRoleID},
+	})
+
+	//the cached user carries the old role, without this the change would wait for UserExpTime
+	app.invalidateUserCache(ctx, userID)
+
+	app.logger.Infow("role assigned", "user", userID, "role", payload.RoleID, "by", getUserFromContext(r).ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// auditRoleChange records the role as it is after the change
+func (app *application) auditRoleChange(r *http.Request, action string, role *store.Role) {
+	app.audit(r, store.AuditEvent{
+		ActorID:    getUserFromContext(r).ID,
+		Action:     action,
+		TargetType: "role",
+		TargetID:   role.ID,
+		Metadata: map[string]any{
+			"name":        role.Name,
+			"level":       role.Level,
+			"permissions": role.Permissions,
+		},
+	})
+}
+
+// invalidateRoleCache drops the cached permission set of the role and the cached users holding it,
+// they carry name and level of the role. See invalidateUserCache
+func (app *application) invalidateRoleCache(ctx context.Context, roleID int64) {
+	if !app.config.redisCfg.enabled {
+		return
+	}
+
+	if err := app.cacheStorage.Roles.DeletePermissions(ctx, roleID); err != nil {
+		app.logger.Errorw("error invalidating cached role permissions", "role", roleID, "error", err)
+	}
+
+	userIDs, err := app.store.Roles.GetUserIDs(ctx, roleID)
+	if err != nil {
+		app.logger.Errorw("error finding users of role", "role", roleID, "error", err)
+		return
+	}
+
+	if err := app.cacheStorage.Users.Delete(ctx, userIDs...); err != nil {
+		app.logger.Errorw("error invalidating cached users of role", "role", roleID, "error", err)
+	}
+}
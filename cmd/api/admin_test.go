@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"social/internal/store"
+	"social/internal/store/cache"
+	"strings"
+	"testing"
+)
+
+func TestAdminRoutes(t *testing.T) {
+	app := newTestApplication(t, config{})
+	mux := app.mount()
+
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should not allow unauthenticated requests", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/v1/admin/roles", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("should forbid users without roles.manage", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/v1/admin/roles", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusForbidden, rr.Code)
+	})
+}
+
+func TestUpdateRole(t *testing.T) {
+	app := newTestApplication(t, config{})
+	app.config.redisCfg.enabled = true
+	app.store.Roles.(*store.MockRoleStore).Permissions = []string{store.PermRolesManage}
+	mux := app.mount()
+
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should drop the cached users holding the role", func(t *testing.T) {
+		body := strings.NewReader(`{"name": "editor", "level": 2}`)
+		req, err := http.NewRequest(http.MethodPatch, "/v1/admin/roles/2", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusOK, rr.Code)
+
+		deleted := app.cacheStorage.Users.(*cache.MockUserStore).Deleted
+		if !slices.Contains(deleted, 1) || !slices.Contains(deleted, 2) {
+			t.Errorf("expected users 1 and 2 to be dropped from the cache, got %v", deleted)
+		}
+	})
+}
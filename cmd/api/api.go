@@ -8,10 +8,12 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"social/internal/blob"
 	"social/internal/env"
 	"social/internal/mailer"
 	"social/internal/store"
 	"social/internal/store/cache"
+	"sync"
 	"syscall"
 	"time"
 
@@ -37,6 +39,26 @@ type application struct {
 	mailer        mailer.Client
 	authenticator auth.Authenticator
 	rateLimiter   ratelimiter.Limiter
+	//social login providers by name, as used in the /authentication/oidc/{provider} routes
+	oidcProviders map[string]*auth.OIDCProvider
+	//rules for new passwords, set on register and reset
+	passwordPolicy *auth.PasswordPolicy
+	//per email, so one address can't be flooded with login links
+	magicLinkLimiter ratelimiter.Limiter
+	//finds the principal of a request in AuthTokenMiddleware
+	authChain auth.Chain
+	//queue of the audit writer, nil until run starts it
+	auditEvents chan *store.AuditEvent
+	//closed by the audit writer once its last batch is written
+	auditDone chan struct{}
+	//last seen times of sessions, waiting to be flushed
+	sessionActivity sessionActivity
+	//bytes of uploaded media, the database only has their keys
+	blobs blob.Store
+	//nil with HS256, otherwise reloaded by the reload-signing-keys job
+	signingKeys *auth.KeySet
+	//background tasks and jobs, run waits for them before returning
+	wg sync.WaitGroup
 }
 
 type config struct {
@@ -49,6 +71,42 @@ type config struct {
 	auth        authConfig
 	redisCfg    redisConfig
 	rateLimiter ratelimiter.Config
+	jobs        jobsConfig
+	audit       auditConfig
+	reactions   reactionsConfig
+	media       mediaConfig
+}
+
+type jobsConfig struct {
+	purgeInterval time.Duration
+	//accounts that didn't activate this long after registering are deleted
+	unactivatedGrace time.Duration
+	//how stale last_seen_at of a session may get
+	sessionFlushInterval time.Duration
+	//deleted users, posts and comments can be restored this long, then they are purged
+	deletedRetention time.Duration
+	//how late the publisher job may be with a scheduled post
+	publishInterval time.Duration
+}
+
+// audit events are queued and written in batches of batchSize, at least every flushInterval
+type auditConfig struct {
+	bufferSize    int
+	batchSize     int
+	flushInterval time.Duration
+}
+
+type reactionsConfig struct {
+	//what PUT /posts/{id}/reactions/{kind} accepts, taking a kind out keeps the reactions already given
+	kinds []string
+}
+
+type mediaConfig struct {
+	//where the local blob store keeps uploads
+	dir            string
+	maxUploadBytes int64
+	//uploads that aren't attached to a post this long after uploading are deleted
+	orphanTTL time.Duration
 }
 
 type redisConfig struct {
@@ -59,14 +117,62 @@ type redisConfig struct {
 }
 
 type authConfig struct {
-	basic basicConfig
-	token tokenConfig
+	//credential extractors of AuthTokenMiddleware in order, see newAuthChain
+	methods   []string
+	basic     basicConfig
+	token     tokenConfig
+	lockout   lockoutConfig
+	mfa       mfaConfig
+	oidc      oidcConfig
+	password  passwordConfig
+	magicLink magicLinkConfig
+}
+
+// login links expire after exp, each email can ask for requestsPerWindow of them per window
+type magicLinkConfig struct {
+	exp               time.Duration
+	requestsPerWindow int
+	window            time.Duration
+}
+
+type passwordConfig struct {
+	minLength int
+	maxLength int
+	//extra breached passwords, one per line, on top of the built-in list
+	breachedFile string
+	argon2       store.Argon2Params
+}
+
+type oidcConfig struct {
+	providers []auth.OIDCConfig
+	//how long the user has to finish logging in at the provider
+	stateExp time.Duration
+}
+
+type mfaConfig struct {
+	challengeExp time.Duration
+	issuer       string
+	//encrypts the TOTP secrets in the database, changing it makes enrolled authenticators unusable
+	secretKey string
+	//roles forced into 2FA on top of the ones granted auth.mfa.required
+	requiredRoles []string
+}
+
+// failed logins allowed before the account is locked for duration
+type lockoutConfig struct {
+	maxAttempts int
+	duration    time.Duration
 }
 
 type tokenConfig struct {
-	secret string
-	exp    time.Duration
-	iss    string
+	secret     string
+	exp        time.Duration
+	refreshExp time.Duration
+	iss        string
+	//alg is HS256 (shared secret) or RS256/EdDSA, the asymmetric ones read <kid>.pem files from keysDir
+	alg           string
+	keysDir       string
+	keysReloadInt time.Duration
 }
 
 type basicConfig struct {
@@ -77,6 +183,7 @@ type basicConfig struct {
 // ex 44 adding mailConfig expiry
 type mailConfig struct {
 	exp       time.Duration
+	resetExp  time.Duration
 	fromEmail string
 	sendGrid  sendGridConfig
 }
@@ -105,9 +212,9 @@ func (app *application) mount() *chi.Mux {
 		AllowedOrigins: []string{env.GetString("CORS_ALLOWED_ORIGIN", "http://localhost:8080")}, // Use this to allow specific origin hosts
 		//AllowedOrigins: []string{"https://*", "http://*"},
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-API-Key", "If-Match", "If-None-Match"},
+		ExposedHeaders:   []string{"Link", "ETag"},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
@@ -117,6 +224,9 @@ func (app *application) mount() *chi.Mux {
 
 	r.Use(middleware.Timeout(60 * time.Second))
 
+	//other services fetch our public keys from here to verify tokens, it is not versioned on purpose
+	r.Get("/.well-known/jwks.json", app.jwksHandler)
+
 	r.Route("/v1", func(r chi.Router) {
 		//ex 50 basic auth, cleaner way to add middleware in chi r.With
 		r.With(app.BasicAuthMiddleware()).Get("/health", app.healthCheckHandler)
@@ -133,43 +243,132 @@ func (app *application) mount() *chi.Mux {
 			//ex 52 using this as middleware for all below post routes
 			r.Use(app.AuthTokenMiddleware)
 			//POST /v1/posts
-			r.Post("/", app.createPostHandler)
+			r.With(app.requireScope(auth.ScopePostsWrite), app.RequirePermission(store.PermPostsCreate)).Post("/", app.createPostHandler)
 			//route for GET /v1/posts/{{postID}} reason we used postID
 			//as we have more methods to filter out by postID like PATCH, Delete posts
 			r.Route("/{postID}", func(r chi.Router) {
 				//putting postsContextMiddleware here so it affects only to above route of posts ID
 				r.Use(app.postsContextMiddleware)
 
-				r.Get("/", app.getPostHandler)
+				r.With(app.requireScope(auth.ScopePostsRead)).Get("/", app.getPostHandler)
 				//exercise 28 updating and deleting handler
 				//r.Delete("/", app.deletePostHandler)
 				//r.Patch("/", app.updatePostHandler)
 				//ex 56 Role base authorization using the middleware checkPostOwnership for update and delete handlers
-				r.Patch("/", app.checkPostOwnership("moderator", app.updatePostHandler))
-				r.Delete("/", app.checkPostOwnership("admin", app.deletePostHandler))
+				r.With(app.requireScope(auth.ScopePostsWrite)).Patch("/", app.checkPostOwnership(store.PermPostsUpdateAny, app.updatePostHandler))
+				r.With(app.requireScope(auth.ScopePostsWrite)).Delete("/", app.checkPostOwnership(store.PermPostsDeleteAny, app.deletePostHandler))
+
+				r.With(app.requireScope(auth.ScopePostsWrite)).Post("/publish", app.checkPostOwnership(store.PermPostsUpdateAny, app.publishPostHandler))
+				r.Route("/schedule", func(r chi.Router) {
+					r.Use(app.requireScope(auth.ScopePostsWrite))
+					r.Put("/", app.checkPostOwnership(store.PermPostsUpdateAny, app.schedulePostHandler))
+					r.Delete("/", app.checkPostOwnership(store.PermPostsUpdateAny, app.unschedulePostHandler))
+				})
+
+				r.Route("/revisions", func(r chi.Router) {
+					r.With(app.requireScope(auth.ScopePostsRead)).Get("/", app.checkPostOwnership(store.PermPostsUpdateAny, app.listPostRevisionsHandler))
+					r.With(app.requireScope(auth.ScopePostsWrite)).Post("/{version}/restore", app.checkPostOwnership(store.PermPostsUpdateAny, app.restorePostRevisionHandler))
+				})
+
+				r.Route("/reactions", func(r chi.Router) {
+					r.With(app.requireScope(auth.ScopePostsRead)).Get("/", app.listReactionsHandler)
+					r.With(app.requireScope(auth.ScopePostsWrite)).Put("/{kind}", app.reactHandler)
+					r.With(app.requireScope(auth.ScopePostsWrite)).Delete("/{kind}", app.unreactHandler)
+				})
+
+				r.Route("/comments", func(r chi.Router) {
+					r.With(app.requireScope(auth.ScopePostsRead)).Get("/", app.listCommentsHandler)
+					r.With(app.requireScope(auth.ScopePostsWrite), app.RequirePermission(store.PermCommentsCreate)).Post("/", app.createCommentHandler)
+
+					r.Route("/{commentID}", func(r chi.Router) {
+						r.Use(app.commentsContextMiddleware)
+						r.With(app.requireScope(auth.ScopePostsRead)).Get("/", app.getCommentThreadHandler)
+						r.With(app.requireScope(auth.ScopePostsWrite)).Patch("/", app.checkCommentOwnership(store.PermCommentsUpdateAny, app.updateCommentHandler))
+						r.With(app.requireScope(auth.ScopePostsWrite)).Delete("/", app.checkCommentOwnership(store.PermCommentsDeleteAny, app.deleteCommentHandler))
+					})
+				})
 
 			})
 		})
 
+		r.Route("/media", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.With(app.requireScope(auth.ScopePostsWrite), app.RequirePermission(store.PermPostsCreate)).Post("/", app.uploadMediaHandler)
+
+			r.Route("/{mediaID}", func(r chi.Router) {
+				r.Use(app.requireScope(auth.ScopePostsRead), app.mediaContextMiddleware)
+				r.Get("/", app.getMediaHandler)
+				r.Get("/thumbnail", app.getMediaThumbnailHandler)
+			})
+		})
+
 		// /v1/users
 		r.Route("/users", func(r chi.Router) {
 			//ex 45 User Activation
 			r.Put("/activate/{token}", app.activateUserHandler)
+			r.Post("/activation/resend", app.resendActivationHandler)
+			r.Put("/email/confirm/{token}", app.confirmEmailHandler)
+
+			//the authenticated user's own account
+			r.Route("/me", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.requireSession)
+				r.Delete("/", app.deleteAccountHandler)
+				r.Patch("/email", app.changeEmailHandler)
+				r.Get("/drafts", app.listDraftsHandler)
+				r.Route("/mfa/totp", func(r chi.Router) {
+					r.Post("/", app.enrollTOTPHandler)
+					r.Post("/confirm", app.confirmTOTPHandler)
+					r.Delete("/", app.disableTOTPHandler)
+				})
+				r.Route("/sessions", func(r chi.Router) {
+					r.Get("/", app.listSessionsHandler)
+					r.Delete("/{sessionID}", app.revokeSessionHandler)
+				})
+				r.Route("/api-keys", func(r chi.Router) {
+					r.Post("/", app.createAPIKeyHandler)
+					r.Get("/", app.listAPIKeysHandler)
+					r.Delete("/{keyID}", app.revokeAPIKeyHandler)
+				})
+			})
 
 			//Get for profile fetching exercise 34
 			r.Route("/{userID}", func(r chi.Router) {
 				//ex 52 using this as middleware for all below accessing user by ID routes
 				r.Use(app.AuthTokenMiddleware)
-				r.Get("/", app.getUserHandler)
+				r.With(app.requireScope(auth.ScopeUsersRead)).Get("/", app.getUserHandler)
 				// need route PUT /v1/users/42/follow for follow. exercise 35
 				//we can use route DELETE /v1/users/42/follow for unfollow. But we use same PUT for follow and unfollow
-				r.Put("/follow", app.followUserHandler)
-				r.Put("/unfollow", app.unfollowUserHandler)
+				r.With(app.requireScope(auth.ScopeUsersWrite)).Put("/follow", app.followUserHandler)
+				r.With(app.requireScope(auth.ScopeUsersWrite)).Put("/unfollow", app.unfollowUserHandler)
 			})
 			//creating user feed like we have on facebook/instagram exercise 37 v1/users/12/feed who is userID we want
 			r.Group(func(r chi.Router) {
 				r.Use(app.AuthTokenMiddleware)
-				r.Get("/feed", app.getUserFeedHandler)
+				r.With(app.requireScope(auth.ScopeFeedRead)).Get("/feed", app.getUserFeedHandler)
+			})
+		})
+
+		// /v1/admin, every group in here needs its own permission
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.requireSession)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.RequirePermission(store.PermRolesManage))
+				r.Get("/roles", app.listRolesHandler)
+				r.Post("/roles", app.createRoleHandler)
+				r.Patch("/roles/{roleID}", app.updateRoleHandler)
+				r.Put("/users/{userID}/role", app.assignRoleHandler)
+			})
+
+			r.With(app.RequirePermission(store.PermAuditRead)).Get("/audit", app.listAuditEventsHandler)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.RequirePermission(store.PermContentRestore))
+				r.Post("/posts/{postID}/restore", app.restorePostHandler)
+				r.Post("/comments/{commentID}/restore", app.restoreCommentHandler)
+				r.Post("/users/{userID}/restore", app.restoreUserHandler)
 			})
 		})
 
@@ -179,6 +378,22 @@ func (app *application) mount() *chi.Mux {
 			r.Post("/user", app.registerUserHandler)
 			//ex 51 JWT generate token
 			r.Post("/token", app.createTokenHandler)
+			r.Post("/refresh", app.refreshTokenHandler)
+			r.Put("/unlock/{token}", app.unlockUserHandler)
+			r.Post("/password/forgot", app.forgotPasswordHandler)
+			r.Put("/password/reset/{token}", app.resetPasswordHandler)
+			r.Post("/magic-link", app.requestMagicLinkHandler)
+			r.Post("/magic-link/redeem", app.redeemMagicLinkHandler)
+			r.Post("/mfa", app.verifyMFAHandler)
+			r.Get("/oidc/{provider}/login", app.oidcLoginHandler)
+			r.Get("/oidc/{provider}/callback", app.oidcCallbackHandler)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.requireSession)
+				r.Post("/logout", app.logoutHandler)
+				r.Post("/logout/all", app.logoutAllHandler)
+			})
 		})
 
 	})
@@ -199,6 +414,12 @@ func (app *application) run(mux *chi.Mux) error {
 		ReadTimeout:  time.Second * 10,
 		IdleTimeout:  time.Minute,
 	}
+	//jobs stop when this is cancelled on shutdown
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	app.startJobs(jobsCtx)
+	app.startAuditWriter()
+
 	//ex 17 graceful server shutdown
 	shutdown := make(chan error)
 
@@ -214,6 +435,7 @@ func (app *application) run(mux *chi.Mux) error {
 
 		app.logger.Infow("signal caught", "signal", s.String())
 
+		stopJobs()
 		shutdown <- srv.Shutdown(ctx)
 	}()
 
@@ -229,6 +451,11 @@ func (app *application) run(mux *chi.Mux) error {
 		return err
 	}
 
+	//emails still being sent and jobs still running finish before we exit, then the audit
+	//events they recorded are flushed
+	app.logger.Infow("waiting for background tasks")
+	app.waitForBackground()
+
 	app.logger.Infow("server has stopped", "addr", app.config.addr, "env", app.config.env)
 
 	return nil
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"social/internal/auth"
+	"social/internal/store"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type CreateAPIKeyPayload struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=posts:read posts:write feed:read users:read users:write"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyWithSecret is only ever returned once, when the key is created
+type APIKeyWithSecret struct {
+	*store.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey godoc
+//
+//	@Summary		Creates an API key
+//	@Description	Creates a named API key limited to the given scopes. The key is only shown in this response
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateAPIKeyPayload	true	"API key"
+//	@Success		201		{object}	APIKeyWithSecret
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/api-keys [post]
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	var payload CreateAPIKeyPayload
+	err := readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = Validate.Struct(payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if payload.ExpiresAt != nil && payload.ExpiresAt.Before(time.Now()) {
+		app.badRequestError(w, r, errors.New("expires_at must be in the future"))
+		return
+	}
+
+	secret, err := auth.NewOpaqueToken()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	plainKey := auth.APIKeyPrefix + secret
+
+	key := &store.APIKey{
+		UserID:    user.ID,
+		Name:      payload.Name,
+		Prefix:    plainKey[:len(auth.APIKeyPrefix)+8],
+		Scopes:    payload.Scopes,
+		ExpiresAt: payload.ExpiresAt,
+	}
+
+	if err := app.store.APIKeys.Create(r.Context(), key, plainKey); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, store.AuditEvent{
+		ActorID:    user.ID,
+		Action:     store.AuditAPIKeyCreated,
+		TargetType: "api_key",
+		TargetID:   key.ID,
+		Metadata:   map[string]any{"scopes": key.Scopes},
+	})
+
+	if err := app.jsonResponse(w, http.StatusCreated, APIKeyWithSecret{APIKey: key, Key: plainKey}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// ListAPIKeys godoc
+//
+//	@Summary		Lists API keys
+//	@Description	Lists the active API keys of the authenticated user, without the secret part
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{object}	[]store.APIKey
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/api-keys [get]
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	keys, err := app.store.APIKeys.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, keys); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// RevokeAPIKey godoc
+//
+//	@Summary		Revokes an API key
+//	@Description	Revokes one of the authenticated user's API keys, it stops working immediately
+//	@Tags			users
+//	@Produce		json
+//	@Param			keyID	path		int		true	"API key ID"
+//	@Success		204		{string}	string	"API key revoked"
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/api-keys/{keyID} [delete]
+func (app *application) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	keyID, err := strconv.ParseInt(chi.URLParam(r, "keyID"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = app.store.APIKeys.Revoke(r.Context(), keyID, user.ID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, store.AuditEvent{ActorID: user.ID, Action: store.AuditAPIKeyRevoked, TargetType: "api_key", TargetID: keyID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
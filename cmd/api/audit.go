@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"social/internal/store"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// audit records a security event without slowing down the request, the event is queued for the audit
+// writer. When the queue is full (or the writer isn't running, like in tests) it is written in the background
+func (app *application) audit(r *http.Request, event store.AuditEvent) {
+	event.IP = clientIP(r)
+	event.RequestID = middleware.GetReqID(r.Context())
+	event.CreatedAt = time.Now()
+
+	select {
+	case app.auditEvents <- &event:
+	default:
+		app.background(func() {
+			app.writeAuditEvents([]*store.AuditEvent{&event})
+		})
+	}
+}
+
+// startAuditWriter writes queued events in batches until stopAuditWriter. It isn't part of app.wg,
+// background tasks audit too and have to finish before the writer stops
+func (app *application) startAuditWriter() {
+	cfg := app.config.audit
+	events := make(chan *store.AuditEvent, cfg.bufferSize)
+	done := make(chan struct{})
+	app.auditEvents = events
+	app.auditDone = done
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(cfg.flushInterval)
+		defer ticker.Stop()
+
+		batch := make([]*store.AuditEvent, 0, cfg.batchSize)
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			app.writeAuditEvents(batch)
+			batch = make([]*store.AuditEvent, 0, cfg.batchSize)
+		}
+
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					flush()
+					return
+				}
+				batch = append(batch, event)
+				if len(batch) >= cfg.batchSize {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+}
+
+// stopAuditWriter flushes the queue and waits for the last batch, it must only be called once neither
+// handlers nor background tasks can call audit anymore
+func (app *application) stopAuditWriter() {
+	close(app.auditEvents)
+	<-app.auditDone
+}
+
+// writeAuditEvents doesn't retry, a failed batch at least ends up in the logs so it isn't lost silently
+func (app *application) writeAuditEvents(events []*store.AuditEvent) {
+	if err := app.store.Audit.Create(context.Background(), events); err != nil {
+		app.logger.Errorw("error writing audit events", "count", len(events), "events", events, "error", err)
+	}
+}
+
+// clientIP is the address set by middleware.RealIP, without the port when it is the connection address
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ListAuditEvents godoc
+//
+//	@Summary		Lists audit events
+//	@Description	Lists security audit events, newest first, including role changes
+//	@Tags			admin
+//	@Produce		json
+//	@Param			limit		query		int		false	"Limit"
+//	@Param			offset		query		int		false	"Offset"
+//	@Param			actor_id	query		int		false	"Actor user ID"
+//	@Param			action		query		string	false	"Action, e.g. auth.login_failed"
+//	@Param			target_type	query		string	false	"Target type, e.g. post"
+//	@Param			target_id	query		int		false	"Target ID"
+//	@Param			since		query		string	false	"RFC 3339 time, inclusive"
+//	@Param			until		query		string	false	"RFC 3339 time, exclusive"
+//	@Success		200			{object}	[]store.AuditEvent
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/audit [get]
+func (app *application) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	q := store.AuditQuery{
+		Limit:  50,
+		Offset: 0,
+	}
+
+	q, err := q.Parse(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	events, err := app.store.Audit.List(r.Context(), q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, events); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"social/internal/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	t.Run("should record denied permissions", func(t *testing.T) {
+		app := newTestApplication(t, config{})
+		mux := app.mount()
+		auditStore := app.store.Audit.(*store.MockAuditStore)
+
+		testToken, err := app.authenticator.GenerateToken(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/v1/admin/audit", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+		checkResponseCode(t, http.StatusForbidden, rr.Code)
+
+		//no writer is running in tests, the event is written in the background
+		app.wg.Wait()
+
+		if !slices.Contains(auditStore.Actions(), store.AuditPermissionDenied) {
+			t.Errorf("expected %s to be recorded, got %v", store.AuditPermissionDenied, auditStore.Actions())
+		}
+	})
+
+	t.Run("should record role changes", func(t *testing.T) {
+		app := newTestApplication(t, config{})
+		app.store.Roles.(*store.MockRoleStore).Permissions = []string{store.PermRolesManage}
+		mux := app.mount()
+		auditStore := app.store.Audit.(*store.MockAuditStore)
+
+		testToken, err := app.authenticator.GenerateToken(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodPut, "/v1/admin/users/7/role", strings.NewReader(`{"role_id": 2}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+		checkResponseCode(t, http.StatusNoContent, rr.Code)
+
+		app.wg.Wait()
+
+		if !slices.Contains(auditStore.Actions(), store.AuditRoleAssigned) {
+			t.Errorf("expected %s to be recorded, got %v", store.AuditRoleAssigned, auditStore.Actions())
+		}
+	})
+
+	t.Run("should flush queued events when the writer stops", func(t *testing.T) {
+		app := newTestApplication(t, config{})
+		app.config.audit = auditConfig{bufferSize: 10, batchSize: 5, flushInterval: time.Hour}
+		auditStore := app.store.Audit.(*store.MockAuditStore)
+
+		app.startAuditWriter()
+
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/logout", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "203.0.113.7:4242"
+
+		for range 3 {
+			app.audit(req, store.AuditEvent{ActorID: 1, Action: store.AuditLogout})
+		}
+
+		app.stopAuditWriter()
+
+		if len(auditStore.Events) != 3 {
+			t.Fatalf("expected 3 events, got %d", len(auditStore.Events))
+		}
+		if ip := auditStore.Events[0].IP; ip != "203.0.113.7" {
+			t.Errorf("expected the ip without the port, got %q", ip)
+		}
+	})
+
+	t.Run("should write events of background tasks still running on shutdown", func(t *testing.T) {
+		app := newTestApplication(t, config{})
+		app.config.audit = auditConfig{bufferSize: 10, batchSize: 5, flushInterval: time.Hour}
+		auditStore := app.store.Audit.(*store.MockAuditStore)
+
+		app.startAuditWriter()
+
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/token", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		started := make(chan struct{})
+		app.background(func() {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			app.audit(req, store.AuditEvent{Action: store.AuditLoginFailed})
+		})
+		<-started
+
+		app.waitForBackground()
+
+		if !slices.Contains(auditStore.Actions(), store.AuditLoginFailed) {
+			t.Errorf("expected %s to be recorded, got %v", store.AuditLoginFailed, auditStore.Actions())
+		}
+	})
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"social/internal/auth"
 	"social/internal/mailer"
 	"social/internal/store"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
@@ -16,7 +20,8 @@ import (
 type RegisterUserPayload struct {
 	Username string `json:"username" validate:"required,max=100"`
 	Email    string `json:"email" validate:"required,email,max=255"`
-	Password string `json:"password" validate:"required,min=3,max=72"`
+	//length rules are the password policy's job, max only keeps absurd payloads out
+	Password string `json:"password" validate:"required,max=1024"`
 }
 
 type UserWithToken struct {
@@ -50,6 +55,11 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if err := app.passwordPolicy.Check(payload.Password); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
 	user := &store.User{
 		Username: payload.Username,
 		Email:    payload.Email,
@@ -60,11 +70,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		// },
 	}
 
-	//hash the password check api/users.go file where user struct field of password is set to password type
-	if err := user.Password.Set(payload.Password); err != nil {
-		app.internalServerError(w, r, err)
-		return
-	}
+	//the store hashes the password when it creates the user, check store/users.go for the password type
+	user.Password.SetPlaintext(payload.Password)
 
 	ctx := r.Context()
 
@@ -123,6 +130,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	app.logger.Infow("Email sent", "status code", status)
 
+	app.audit(r, store.AuditEvent{ActorID: user.ID, Action: store.AuditUserRegistered, TargetType: "user", TargetID: user.ID})
+
 	err = app.jsonResponse(w, http.StatusCreated, userwithToken)
 	if err != nil {
 		app.internalServerError(w, r, err)
@@ -131,20 +140,31 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 }
 
+var errInvalidCredentials = errors.New("invalid credentials")
+
 type CreateUserTokenPayload struct {
 	Email    string `json:"email" validate:"required,email,max=255"`
-	Password string `json:"password" validate:"required,min=3,max=72"`
+	Password string `json:"password" validate:"required,max=1024"`
+}
+
+// TokenResponse is what the client gets back after logging in or refreshing
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
 }
 
 // createTokenHandler godoc
 //
 //	@Summary		Creates a token
-//	@Description	Creates a token for a user
+//	@Description	Creates an access and refresh token pair for a user
 //	@Tags			authentication
 //	@Accept			json
 //	@Produce		json
 //	@Param			payload	body		CreateUserTokenPayload	true	"User credentials"
-//	@Success		200		{string}	string					"Token"
+//	@Success		201		{object}	TokenResponse			"Tokens"
+//	@Success		200		{object}	MFAChallengeResponse	"Second factor required"
 //	@Failure		400		{object}	error
 //	@Failure		401		{object}	error
 //	@Failure		500		{object}	error
@@ -158,9 +178,274 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 		app.badRequestError(w, r, err)
 		return
 	}
+
+	err = Validate.Struct(payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
 	//fetch the user (check if user exist) from the payload
-	user, err := app.store.Users.GetByEmail(r.Context(), payload.Email)
+	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			//still pay for a hash comparison so an unknown email takes as long as a wrong password
+			_ = app.store.Users.ComparePassword(nil, payload.Password)
+			app.audit(r, store.AuditEvent{
+				Action:   store.AuditLoginFailed,
+				Metadata: map[string]any{"reason": "unknown_email", "email": payload.Email},
+			})
+			app.unauthorizedErrorResponse(w, r, errInvalidCredentials)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	//compare before looking at the lock, so a locked account answers just as slow and just as vague
+	err = app.store.Users.ComparePassword(user, payload.Password)
+	if user.IsLocked() {
+		app.audit(r, store.AuditEvent{
+			ActorID:  user.ID,
+			Action:   store.AuditLoginFailed,
+			Metadata: map[string]any{"reason": "locked"},
+		})
+		app.unauthorizedErrorResponse(w, r, fmt.Errorf("account %d is locked", user.ID))
+		return
+	}
+	if err != nil {
+		switch err {
+		case store.ErrPasswordMismatch:
+			app.recordFailedLogin(r, user, "wrong_password")
+			app.unauthorizedErrorResponse(w, r, errInvalidCredentials)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+		if err := app.store.Users.ResetFailedLogins(ctx, user.ID); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	app.rehashPassword(ctx, user, payload.Password)
+
+	app.completeLogin(w, r, user, "password")
+}
+
+// completeLogin runs once the first factor checked out: users with 2FA get a challenge, everybody else tokens.
+// method is how the first factor was checked, it goes to the audit log
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *store.User, method string) {
+	if user.MFAEnabled {
+		challenge, err := app.generateMFAChallenge(user.ID)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if err := app.jsonResponse(w, http.StatusOK, challenge); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	//every login starts a new session
+	tokens, err := app.issueTokens(r, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, store.AuditEvent{
+		ActorID:  user.ID,
+		Action:   store.AuditLogin,
+		Metadata: map[string]any{"method": method, "mfa": false},
+	})
+
+	app.setSessionCookie(w, tokens)
+
+	//send it to the client
+	err = app.jsonResponse(w, http.StatusCreated, tokens)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// rehashPassword upgrades bcrypt hashes and argon2id hashes with old parameters, the plaintext is only
+// around during a login. Failing is logged, the old hash keeps working
+func (app *application) rehashPassword(ctx context.Context, user *store.User, password string) {
+	if !user.Password.NeedsRehash() {
+		return
+	}
+
+	user.Password.SetPlaintext(password)
+	if err := app.store.Users.UpdatePassword(ctx, user); err != nil {
+		app.logger.Errorw("error storing rehashed password", "user", user.ID, "error", err)
+		return
+	}
+
+	app.logger.Infow("password hash upgraded", "user", user.ID)
+}
+
+// recordFailedLogin counts the failed attempt and emails an unlock link when it locks the account.
+// Errors are only logged, the client gets the same 401 either way
+func (app *application) recordFailedLogin(r *http.Request, user *store.User, reason string) {
+	lockout := app.config.auth.lockout
+
+	app.audit(r, store.AuditEvent{
+		ActorID:  user.ID,
+		Action:   store.AuditLoginFailed,
+		Metadata: map[string]any{"reason": reason},
+	})
+
+	//an unknown email does none of this, doing the UPDATE inline would make a known email answer slower.
+	//The request context is gone once the 401 is written, so the store calls get their own
+	app.background(func() {
+		ctx := context.Background()
+
+		locked, err := app.store.Users.RecordFailedLogin(ctx, user.ID, lockout.maxAttempts, lockout.duration)
+		if err != nil {
+			app.logger.Errorw("error recording failed login", "user", user.ID, "error", err)
+			return
+		}
+		if !locked {
+			return
+		}
+
+		app.logger.Warnw("account locked after failed logins", "user", user.ID)
+		app.audit(r, store.AuditEvent{
+			ActorID:  user.ID,
+			Action:   store.AuditAccountLocked,
+			Metadata: map[string]any{"duration": lockout.duration.String()},
+		})
+
+		plainToken := uuid.New().String()
+		err = app.store.Users.CreateToken(ctx, user.ID, store.ScopeUnlock, plainToken, lockout.duration)
+		if err != nil {
+			app.logger.Errorw("error creating unlock token", "user", user.ID, "error", err)
+			return
+		}
+
+		unlockURL := fmt.Sprintf("%s/unlock/%s", app.config.frontendURL, plainToken)
+
+		isProdEnv := app.config.env == "production"
+		vars := struct {
+			Username        string
+			UnlockURL       string
+			LockoutDuration string
+		}{
+			Username:        user.Username,
+			UnlockURL:       unlockURL,
+			LockoutDuration: lockout.duration.String(),
+		}
+
+		status, err := app.mailer.Send(mailer.AccountUnlockTemplate, user.Username, user.Email, vars, !isProdEnv)
+		if err != nil {
+			app.logger.Errorw("error sending unlock email", "user", user.ID, "error", err)
+			return
+		}
+
+		app.logger.Infow("Email sent", "status code", status)
+	})
+}
+
+// UnlockUser godoc
+//
+//	@Summary		Unlocks a user
+//	@Description	Unlocks an account locked after too many failed logins, using the token from the unlock email
+//	@Tags			authentication
+//	@Produce		json
+//	@Param			token	path		string	true	"Unlock token"
+//	@Success		204		{string}	string	"User unlocked"
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/unlock/{token} [put]
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	userID, err := app.store.Users.Unlock(r.Context(), token)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, store.AuditEvent{ActorID: userID, Action: store.AuditAccountUnlocked, TargetType: "user", TargetID: userID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// refreshTokenHandler godoc
+//
+//	@Summary		Refreshes a token
+//	@Description	Exchanges a refresh token for a new access and refresh token pair, the old refresh token can't be used again
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		RefreshTokenPayload	true	"Refresh token"
+//	@Success		201		{object}	TokenResponse		"Tokens"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/refresh [post]
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RefreshTokenPayload
+	err := readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = Validate.Struct(payload)
 	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	newRefreshToken, err := auth.NewOpaqueToken()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	rt, err := app.store.RefreshTokens.Rotate(ctx, payload.RefreshToken, newRefreshToken, app.config.auth.token.refreshExp)
+	if err != nil {
+		switch err {
+		case store.ErrTokenReused:
+			//somebody else holds a copy of this token, the store already revoked the whole family
+			app.logger.Warnw("refresh token reuse detected", "path", r.URL.Path)
+			if err := app.revokeFamilyAccess(ctx, rt.FamilyID); err != nil {
+				app.logger.Errorw("error revoking access tokens of a reused family", "family", rt.FamilyID, "error", err)
+			}
+			app.audit(r, store.AuditEvent{Action: store.AuditRefreshTokenReused})
+			app.unauthorizedErrorResponse(w, r, err)
+		case store.ErrNotFound:
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	//the user might have been deleted or deactivated since the last login
+	if _, err := app.store.Users.GetByID(ctx, rt.UserID); err != nil {
 		switch err {
 		case store.ErrNotFound:
 			app.unauthorizedErrorResponse(w, r, err)
@@ -170,24 +455,85 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	accessToken, err := app.generateAccessToken(rt.UserID, rt.FamilyID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	tokens := TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(app.config.auth.token.exp.Seconds()),
+	}
+
+	app.setSessionCookie(w, &tokens)
+
+	err = app.jsonResponse(w, http.StatusCreated, tokens)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// issueTokens starts a new session, its ID is the family of the refresh token and the sid of the access token
+func (app *application) issueTokens(r *http.Request, userID int64) (*TokenResponse, error) {
+	refreshToken, err := auth.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := app.newSession(r, userID, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := app.generateAccessToken(userID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(app.config.auth.token.exp.Seconds()),
+	}, nil
+}
+
+// generateAccessToken signs a short lived JWT, sid ties it to the refresh token family so revoking
+// the family also stops the access token in AuthTokenMiddleware
+func (app *application) generateAccessToken(userID int64, familyID string) (string, error) {
 	//generate the token -> add claims
 	claims := jwt.MapClaims{
-		"sub": user.ID,
+		"sub": userID,
+		"sid": familyID,
+		"jti": uuid.New().String(),
 		"exp": time.Now().Add(app.config.auth.token.exp).Unix(),
-		"iat": time.Now().Unix(),
+		//with microseconds, a token from right after logging out everywhere mustn't look like one from before
+		"iat": float64(time.Now().UnixMicro()) / 1e6,
 		"nbf": time.Now().Unix(),
 		"iss": app.config.auth.token.iss,
 		"aud": app.config.auth.token.iss,
 	}
-	token, err := app.authenticator.GenerateToken(claims)
-	if err != nil {
-		app.internalServerError(w, r, err)
-		return
-	}
 
-	//send it to the client
-	err = app.jsonResponse(w, http.StatusCreated, token)
-	if err != nil {
+	return app.authenticator.GenerateToken(claims)
+}
+
+// jwksHandler godoc
+//
+//	@Summary		Public signing keys
+//	@Description	JSON Web Key Set with the public keys used to sign access tokens, empty in HS256 mode
+//	@Tags			authentication
+//	@Produce		json
+//	@Success		200	{object}	auth.JWKS
+//	@Router			/.well-known/jwks.json [get]
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	//verifiers cache this, rotated keys are published before they start signing
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	if err := writeJSON(w, http.StatusOK, app.authenticator.JWKS()); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
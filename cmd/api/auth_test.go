@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"social/internal/mailer"
+	"social/internal/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateToken(t *testing.T) {
+	app := newTestApplication(t, config{})
+	mux := app.mount()
+
+	t.Run("should reject a wrong password", func(t *testing.T) {
+		body := strings.NewReader(`{"email": "gopher@example.com", "password": "wrong-password"}`)
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/token", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("should reject an invalid payload", func(t *testing.T) {
+		body := strings.NewReader(`{"email": "not-an-email", "password": "secret"}`)
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/token", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestAccountLockout(t *testing.T) {
+	app := newTestApplication(t, config{})
+	app.config.auth.lockout = lockoutConfig{maxAttempts: 3, duration: time.Hour}
+	mux := app.mount()
+
+	users := app.store.Users.(*store.MockUserStore)
+	mails := app.mailer.(*mailer.MockClient)
+
+	login := func() {
+		body := strings.NewReader(`{"email": "gopher@example.com", "password": "wrong-password"}`)
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/token", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+		checkResponseCode(t, http.StatusUnauthorized, rr.Code)
+
+		//failed logins are recorded in the background
+		app.wg.Wait()
+	}
+
+	unlock := func(token string) int {
+		req, err := http.NewRequest(http.MethodPut, "/v1/authentication/unlock/"+token, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return excuteRequest(req, mux).Code
+	}
+
+	t.Run("should lock the account after max attempts", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			login()
+		}
+
+		user, _ := users.GetByEmail(context.Background(), "gopher@example.com")
+		if user.IsLocked() {
+			t.Fatal("expected the account to be unlocked before the last attempt")
+		}
+		if user.FailedLoginAttempts != 2 {
+			t.Errorf("expected 2 failed attempts, got %d", user.FailedLoginAttempts)
+		}
+
+		login()
+
+		user, _ = users.GetByEmail(context.Background(), "gopher@example.com")
+		if !user.IsLocked() {
+			t.Fatal("expected the account to be locked")
+		}
+		if users.Token(store.ScopeUnlock) == "" {
+			t.Error("expected an unlock token")
+		}
+		if n := mails.Count(mailer.AccountUnlockTemplate); n != 1 {
+			t.Errorf("expected 1 unlock email, got %d", n)
+		}
+	})
+
+	t.Run("should reject an unknown unlock token", func(t *testing.T) {
+		checkResponseCode(t, http.StatusBadRequest, unlock("not-a-token"))
+	})
+
+	t.Run("should unlock the account once with the emailed token", func(t *testing.T) {
+		token := users.Token(store.ScopeUnlock)
+
+		checkResponseCode(t, http.StatusNoContent, unlock(token))
+
+		user, _ := users.GetByEmail(context.Background(), "gopher@example.com")
+		if user.IsLocked() {
+			t.Error("expected the account to be unlocked")
+		}
+
+		//the token is single use
+		checkResponseCode(t, http.StatusBadRequest, unlock(token))
+	})
+}
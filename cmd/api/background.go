@@ -0,0 +1,27 @@
+package main
+
+import "fmt"
+
+// background runs fn in its own goroutine so slow work like sending emails doesn't hold up the response,
+// a panic in there is logged instead of taking the whole server down. run waits for these on shutdown
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Errorw("background task panicked", "error", fmt.Sprint(err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// waitForBackground waits for background tasks and jobs first, they can still record audit events,
+// and only then stops the audit writer and waits for its last batch
+func (app *application) waitForBackground() {
+	app.wg.Wait()
+	app.stopAuditWriter()
+}
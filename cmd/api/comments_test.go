@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"social/internal/store"
+	"testing"
+)
+
+func TestComments(t *testing.T) {
+	app := newTestApplication(t, config{})
+	mux := app.mount()
+
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, path string, body any) *http.Request {
+		t.Helper()
+
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		req, err := http.NewRequest(method, path, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		return req
+	}
+
+	t.Run("should comment on a post", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodPost, "/v1/posts/1/comments", CreateCommentPayload{Content: "nice"}), mux)
+		checkResponseCode(t, http.StatusCreated, rr.Code)
+	})
+
+	t.Run("should not allow empty comments", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodPost, "/v1/posts/1/comments", CreateCommentPayload{}), mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("should list comments", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodGet, "/v1/posts/1/comments?limit=10", nil), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("should reject a malformed cursor", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodGet, "/v1/posts/1/comments?cursor=not-a-cursor", nil), mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("should embed the comment count in the post", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodGet, "/v1/posts/1", nil), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+
+		var body struct {
+			Data map[string]any `json:"data"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := body.Data["comment_count"]; !ok {
+			t.Errorf("expected comment_count in %v", body.Data)
+		}
+	})
+
+	t.Run("should let the author edit and delete their comment", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodPatch, "/v1/posts/1/comments/1", UpdateCommentPayload{Content: "edited"}), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+
+		rr = excuteRequest(request(http.MethodDelete, "/v1/posts/1/comments/1", nil), mux)
+		checkResponseCode(t, http.StatusNoContent, rr.Code)
+	})
+
+	t.Run("should only find comments through their own post", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodDelete, "/v1/posts/2/comments/1", nil), mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("should not allow changing somebody else's comment", func(t *testing.T) {
+		app.store.Comments = &store.MockCommentStore{OwnerID: 2}
+		defer func() { app.store.Comments = &store.MockCommentStore{} }()
+
+		rr := excuteRequest(request(http.MethodPatch, "/v1/posts/1/comments/1", UpdateCommentPayload{Content: "edited"}), mux)
+		checkResponseCode(t, http.StatusForbidden, rr.Code)
+
+		rr = excuteRequest(request(http.MethodDelete, "/v1/posts/1/comments/1", nil), mux)
+		checkResponseCode(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("should reply to a comment", func(t *testing.T) {
+		parentID := int64(1)
+		rr := excuteRequest(request(http.MethodPost, "/v1/posts/1/comments", CreateCommentPayload{Content: "agreed", ParentID: &parentID}), mux)
+		checkResponseCode(t, http.StatusCreated, rr.Code)
+	})
+
+	t.Run("should not reply to a comment on another post", func(t *testing.T) {
+		parentID := int64(1)
+		rr := excuteRequest(request(http.MethodPost, "/v1/posts/2/comments", CreateCommentPayload{Content: "agreed", ParentID: &parentID}), mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("should fetch a thread", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodGet, "/v1/posts/1/comments/1?depth=3&limit=5", nil), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+
+		rr = excuteRequest(request(http.MethodGet, "/v1/posts/1/comments/1?depth=6", nil), mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("should keep deleted comments readable but not changeable", func(t *testing.T) {
+		app.store.Comments = &store.MockCommentStore{Deleted: true}
+		defer func() { app.store.Comments = &store.MockCommentStore{} }()
+
+		rr := excuteRequest(request(http.MethodGet, "/v1/posts/1/comments/1", nil), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+
+		rr = excuteRequest(request(http.MethodPatch, "/v1/posts/1/comments/1", UpdateCommentPayload{Content: "edited"}), mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+
+		parentID := int64(1)
+		rr = excuteRequest(request(http.MethodPost, "/v1/posts/1/comments", CreateCommentPayload{Content: "agreed", ParentID: &parentID}), mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+}
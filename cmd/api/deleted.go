@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"social/internal/store"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DeleteAccount godoc
+//
+//	@Summary		Deletes the authenticated user
+//	@Description	Deletes the account and signs it out everywhere. An admin can restore it until the retention period is over
+//	@Tags			users
+//	@Success		204	{string}	string	"Account deleted"
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me [delete]
+func (app *application) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	if err := app.store.Users.Delete(ctx, user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	//refresh tokens were revoked with the user, access tokens go like after logging out everywhere
+	err := app.denylist().RevokeIssuedBefore(ctx, user.ID, time.Now(), app.config.auth.token.exp)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.invalidateUserCache(ctx, user.ID)
+	clearSessionCookie(w)
+	app.audit(r, store.AuditEvent{ActorID: user.ID, Action: store.AuditUserDeleted, TargetType: "user", TargetID: user.ID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreHandler brings back a soft deleted kind of thing, the id is in the URL param of the same name
+func (app *application) restoreHandler(kind, param string, undelete func(context.Context, int64) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, param), 10, 64)
+		if err != nil {
+			app.badRequestError(w, r, err)
+			return
+		}
+
+		if err := undelete(r.Context(), id); err != nil {
+			switch err {
+			case store.ErrNotFound:
+				app.notFoundError(w, r, errors.New(kind+" is not deleted or already purged"))
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		app.audit(r, store.AuditEvent{
+			ActorID:    getUserFromContext(r).ID,
+			Action:     store.AuditContentRestored,
+			TargetType: kind,
+			TargetID:   id,
+		})
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RestorePost godoc
+//
+//	@Summary		Restores a deleted post
+//	@Description	Restores a deleted post the retention job didn't purge yet
+//	@Tags			admin
+//	@Param			postID	path		int		true	"Post ID"
+//	@Success		204		{string}	string	"Post restored"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/posts/{postID}/restore [post]
+func (app *application) restorePostHandler(w http.ResponseWriter, r *http.Request) {
+	app.restoreHandler("post", "postID", app.store.Posts.Undelete)(w, r)
+}
+
+// RestoreComment godoc
+//
+//	@Summary		Restores a deleted comment
+//	@Description	Restores a deleted comment the retention job didn't purge yet
+//	@Tags			admin
+//	@Param			commentID	path		int		true	"Comment ID"
+//	@Success		204			{string}	string	"Comment restored"
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/comments/{commentID}/restore [post]
+func (app *application) restoreCommentHandler(w http.ResponseWriter, r *http.Request) {
+	app.restoreHandler("comment", "commentID", app.store.Comments.Undelete)(w, r)
+}
+
+// RestoreUser godoc
+//
+//	@Summary		Restores a deleted user
+//	@Description	Restores a deleted user the retention job didn't purge yet, with their posts and comments
+//	@Tags			admin
+//	@Param			userID	path		int		true	"User ID"
+//	@Success		204		{string}	string	"User restored"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/restore [post]
+func (app *application) restoreUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.restoreHandler("user", "userID", app.store.Users.Undelete)(w, r)
+}
+
+// purgeDeletedContent hard deletes what was deleted longer than the retention period ago. Users go first,
+// their comments become purgeable with them
+func (app *application) purgeDeletedContent(ctx context.Context) error {
+	deletedBefore := time.Now().Add(-app.config.jobs.deletedRetention)
+
+	users, err := app.store.Users.PurgeDeleted(ctx, deletedBefore)
+	if err != nil {
+		return err
+	}
+
+	posts, err := app.store.Posts.PurgeDeleted(ctx, deletedBefore)
+	if err != nil {
+		return err
+	}
+
+	comments, err := app.store.Comments.PurgeDeleted(ctx, deletedBefore)
+	if err != nil {
+		return err
+	}
+
+	if users > 0 || posts > 0 || comments > 0 {
+		app.logger.Infow("purged deleted content", "users", users, "posts", posts, "comments", comments)
+	}
+
+	return nil
+}
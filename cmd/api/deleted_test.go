@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestSoftDelete(t *testing.T) {
+	app := newTestApplication(t, config{})
+	mux := app.mount()
+
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should forbid restoring without content.restore", func(t *testing.T) {
+		for _, path := range []string{"/v1/admin/posts/1/restore", "/v1/admin/comments/1/restore", "/v1/admin/users/1/restore"} {
+			req, err := http.NewRequest(http.MethodPost, path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+testToken)
+
+			rr := excuteRequest(req, mux)
+
+			checkResponseCode(t, http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("should not allow deleting an account unauthenticated", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, "/v1/users/me", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("should delete the authenticated user", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, "/v1/users/me", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusNoContent, rr.Code)
+	})
+
+	t.Run("should purge deleted content", func(t *testing.T) {
+		if err := app.purgeDeletedContent(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"social/internal/store"
+	"time"
+)
+
+var (
+	errPublishAtNotInFuture = errors.New("publish_at must be in the future")
+	errPostPublished        = errors.New("post is already published")
+)
+
+// ListDrafts godoc
+//
+//	@Summary		Lists the drafts of the authenticated user
+//	@Description	Lists the drafts of the authenticated user newest first, scheduled ones with their publish_at
+//	@Tags			posts
+//	@Produce		json
+//	@Param			limit	query		int		false	"Limit"
+//	@Param			cursor	query		string	false	"Cursor from the previous page"
+//	@Success		200		{object}	store.PostPage
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/drafts [get]
+func (app *application) listDraftsHandler(w http.ResponseWriter, r *http.Request) {
+	q := store.CursorQuery{
+		Limit: 20,
+	}
+
+	q, err := q.Parse(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	page, err := app.store.Posts.GetDrafts(r.Context(), getUserFromContext(r).ID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, page); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// PublishPost godoc
+//
+//	@Summary		Publishes a draft
+//	@Description	Publishes a draft right away, a schedule it had is dropped
+//	@Tags			posts
+//	@Param			postID	path		int		true	"Post ID"
+//	@Success		204		{string}	string	"Post published"
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/publish [post]
+func (app *application) publishPostHandler(w http.ResponseWriter, r *http.Request) {
+	post := getPostFromCtx(r)
+	if !post.Draft {
+		app.conflictResponse(w, r, errPostPublished)
+		return
+	}
+
+	if err := app.store.Posts.Publish(r.Context(), post.ID); err != nil {
+		app.scheduleError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type SchedulePostPayload struct {
+	PublishAt time.Time `json:"publish_at" validate:"required"`
+}
+
+// SchedulePost godoc
+//
+//	@Summary		Schedules a draft
+//	@Description	Sets when the draft is published, a draft that is already scheduled is moved
+//	@Tags			posts
+//	@Accept			json
+//	@Param			postID	path		int					true	"Post ID"
+//	@Param			payload	body		SchedulePostPayload	true	"Schedule payload"
+//	@Success		204		{string}	string				"Post scheduled"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/schedule [put]
+func (app *application) schedulePostHandler(w http.ResponseWriter, r *http.Request) {
+	var payload SchedulePostPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if !payload.PublishAt.After(time.Now()) {
+		app.badRequestError(w, r, errPublishAtNotInFuture)
+		return
+	}
+
+	post := getPostFromCtx(r)
+	if !post.Draft {
+		app.conflictResponse(w, r, errPostPublished)
+		return
+	}
+
+	if err := app.store.Posts.Schedule(r.Context(), post.ID, &payload.PublishAt); err != nil {
+		app.scheduleError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnschedulePost godoc
+//
+//	@Summary		Unschedules a draft
+//	@Description	The draft stays a draft until it is published or scheduled again
+//	@Tags			posts
+//	@Param			postID	path		int		true	"Post ID"
+//	@Success		204		{string}	string	"Post unscheduled"
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/schedule [delete]
+func (app *application) unschedulePostHandler(w http.ResponseWriter, r *http.Request) {
+	post := getPostFromCtx(r)
+	if !post.Draft {
+		app.conflictResponse(w, r, errPostPublished)
+		return
+	}
+
+	if err := app.store.Posts.Schedule(r.Context(), post.ID, nil); err != nil {
+		app.scheduleError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scheduleError handles a draft that is gone by the time we change it, most likely the publisher job got there first
+func (app *application) scheduleError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case store.ErrNotFound:
+		app.conflictResponse(w, r, errPostPublished)
+	default:
+		app.internalServerError(w, r, err)
+	}
+}
+
+// publishScheduledPosts publishes the drafts whose publish_at is due, followers see them in their feed from then on
+func (app *application) publishScheduledPosts(ctx context.Context) error {
+	published, err := app.store.Posts.PublishDue(ctx)
+	if err != nil {
+		return err
+	}
+
+	if published > 0 {
+		app.logger.Infow("published scheduled posts", "posts", published)
+	}
+
+	return nil
+}
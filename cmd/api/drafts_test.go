@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"social/internal/store"
+	"testing"
+	"time"
+)
+
+func TestDrafts(t *testing.T) {
+	app := newTestApplication(t, config{})
+	mux := app.mount()
+
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, path string, body []byte) *http.Request {
+		t.Helper()
+
+		req, err := http.NewRequest(method, path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		return req
+	}
+
+	future := []byte(`{"publish_at": "` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`)
+	past := []byte(`{"publish_at": "` + time.Now().Add(-time.Hour).Format(time.RFC3339) + `"}`)
+
+	t.Run("should list the drafts of the authenticated user", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodGet, "/v1/users/me/drafts?limit=5", nil), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("should not schedule posts in the past", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodPost, "/v1/posts", []byte(`{"title": "t", "content": "c", "publish_at": "2000-01-01T00:00:00Z"}`)), mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+
+		app.store.Posts = &store.MockPostStore{Draft: true}
+		rr = excuteRequest(request(http.MethodPut, "/v1/posts/1/schedule", past), mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("should schedule, unschedule and publish a draft", func(t *testing.T) {
+		app.store.Posts = &store.MockPostStore{Draft: true}
+
+		rr := excuteRequest(request(http.MethodPut, "/v1/posts/1/schedule", future), mux)
+		checkResponseCode(t, http.StatusNoContent, rr.Code)
+
+		rr = excuteRequest(request(http.MethodDelete, "/v1/posts/1/schedule", nil), mux)
+		checkResponseCode(t, http.StatusNoContent, rr.Code)
+
+		rr = excuteRequest(request(http.MethodPost, "/v1/posts/1/publish", nil), mux)
+		checkResponseCode(t, http.StatusNoContent, rr.Code)
+	})
+
+	t.Run("should not publish a published post again", func(t *testing.T) {
+		app.store.Posts = &store.MockPostStore{}
+
+		rr := excuteRequest(request(http.MethodPost, "/v1/posts/1/publish", nil), mux)
+		checkResponseCode(t, http.StatusConflict, rr.Code)
+
+		rr = excuteRequest(request(http.MethodPut, "/v1/posts/1/schedule", future), mux)
+		checkResponseCode(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("should hide drafts from everybody but the author", func(t *testing.T) {
+		app.store.Posts = &store.MockPostStore{OwnerID: 2, Draft: true}
+
+		for _, req := range []*http.Request{
+			request(http.MethodGet, "/v1/posts/1", nil),
+			request(http.MethodGet, "/v1/posts/1/comments", nil),
+			request(http.MethodPut, "/v1/posts/1/reactions/like", nil),
+		} {
+			rr := excuteRequest(req, mux)
+			//notFoundError answers with 400
+			checkResponseCode(t, http.StatusBadRequest, rr.Code)
+		}
+
+		app.store.Posts = &store.MockPostStore{Draft: true}
+		rr := excuteRequest(request(http.MethodGet, "/v1/posts/1", nil), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("should publish due posts", func(t *testing.T) {
+		if err := app.publishScheduledPosts(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"social/internal/mailer"
+	"social/internal/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type ChangeEmailPayload struct {
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,max=1024"`
+}
+
+// ChangeEmail godoc
+//
+//	@Summary		Changes the email
+//	@Description	Sends a confirmation link to the new email and a notice to the current one. The current email
+//	@Description	stays in use until the link is confirmed
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ChangeEmailPayload	true	"New email and current password"
+//	@Success		202		{string}	string				"Confirmation link sent"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		409		{object}	error	"Email already in use"
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/email [patch]
+func (app *application) changeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ChangeEmailPayload
+	err := readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = Validate.Struct(payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	//the cached user has no password hash, and a stolen session alone must not be enough to take over the account
+	user, err := app.store.Users.GetByID(ctx, getUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	err = app.store.Users.ComparePassword(user, payload.Password)
+	if err != nil {
+		switch err {
+		case store.ErrPasswordMismatch:
+			app.recordFailedLogin(r, user, "wrong_password")
+			app.unauthorizedErrorResponse(w, r, errInvalidCredentials)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	plainToken := uuid.New().String()
+	err = app.store.Users.RequestEmailChange(ctx, user.ID, payload.Email, plainToken, app.config.mail.resetExp)
+	if err != nil {
+		switch err {
+		case store.ErrDuplicateEmail:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, store.AuditEvent{
+		ActorID:    user.ID,
+		Action:     store.AuditEmailChangeRequested,
+		TargetType: "user",
+		TargetID:   user.ID,
+		Metadata:   map[string]any{"new_email": payload.Email},
+	})
+
+	confirmURL := fmt.Sprintf("%s/email/confirm/%s", app.config.frontendURL, plainToken)
+
+	isProdEnv := app.config.env == "production"
+	confirmVars := struct {
+		Username   string
+		ConfirmURL string
+		Expiry     string
+	}{
+		Username:   user.Username,
+		ConfirmURL: confirmURL,
+		Expiry:     app.config.mail.resetExp.String(),
+	}
+	noticeVars := struct {
+		Username string
+		NewEmail string
+	}{
+		Username: user.Username,
+		NewEmail: payload.Email,
+	}
+
+	app.background(func() {
+		status, err := app.mailer.Send(mailer.EmailChangeConfirmTemplate, user.Username, payload.Email, confirmVars, !isProdEnv)
+		if err != nil {
+			app.logger.Errorw("error sending email change confirmation", "user", user.ID, "error", err)
+			return
+		}
+		app.logger.Infow("Email sent", "status code", status)
+
+		status, err = app.mailer.Send(mailer.EmailChangeNoticeTemplate, user.Username, user.Email, noticeVars, !isProdEnv)
+		if err != nil {
+			app.logger.Errorw("error sending email change notice", "user", user.ID, "error", err)
+			return
+		}
+		app.logger.Infow("Email sent", "status code", status)
+	})
+
+	if err := app.jsonResponse(w, http.StatusAccepted, "check your new email to confirm the change"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// ConfirmEmail godoc
+//
+//	@Summary		Confirms an email change
+//	@Description	Switches the account to the new email using the token from the confirmation email
+//	@Tags			users
+//	@Param			token	path		string	true	"Confirmation token"
+//	@Success		204		{string}	string	"Email changed"
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error	"Email already in use"
+//	@Failure		500		{object}	error
+//	@Router			/users/email/confirm/{token} [put]
+func (app *application) confirmEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	ctx := r.Context()
+
+	change, err := app.store.Users.ConfirmEmailChange(ctx, token)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundError(w, r, err)
+		case store.ErrDuplicateEmail:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	//the cached copy still carries the old email
+	app.invalidateUserCache(ctx, change.UserID)
+
+	app.logger.Infow("email changed", "user", change.UserID)
+	app.audit(r, store.AuditEvent{
+		ActorID:    change.UserID,
+		Action:     store.AuditEmailChanged,
+		TargetType: "user",
+		TargetID:   change.UserID,
+		Metadata:   map[string]any{"old_email": change.OldEmail, "new_email": change.NewEmail},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -21,6 +21,18 @@ func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request
 	writeJSONError(w, http.StatusForbidden, "forbidden")
 }
 
+func (app *application) insufficientScopeResponse(w http.ResponseWriter, r *http.Request, scope string) {
+	app.logger.Warnw("insufficient scope", "method", r.Method, "path", r.URL.Path, "scope", scope)
+
+	writeJSONError(w, http.StatusForbidden, "api key is missing the "+scope+" scope")
+}
+
+func (app *application) mfaEnrollmentRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	app.logger.Warnw("mfa enrollment required", "method", r.Method, "path", r.URL.Path)
+
+	writeJSONError(w, http.StatusForbidden, "two-factor authentication must be enabled for your role")
+}
+
 func (app *application) badRequestError(w http.ResponseWriter, r *http.Request, err error) {
 	//log.Printf("bad request error: %s path: %s error: %s", r.Method, r.URL.Path, err)
 	app.logger.Warnf("bad request", "method", r.Method, "path", r.URL.Path, "error", err.Error())
@@ -67,3 +79,34 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 	w.Header().Set("Retry-After", retryAfter)
 	writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded, retry after: "+retryAfter)
 }
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	app.logger.Warnw("precondition failed", "method", r.Method, "path", r.URL.Path, "if_match", r.Header.Get("If-Match"))
+
+	writeJSONError(w, http.StatusPreconditionFailed, "the resource was changed, fetch it again")
+}
+
+// editConflictResponse is a conflictResponse that also sends what the resource looks like now, so the
+// client can merge its change without another request
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request, current any) {
+	app.logger.Warnw("edit conflict", "method", r.Method, "path", r.URL.Path)
+
+	type envelope struct {
+		Error string `json:"error"`
+		Data  any    `json:"data"`
+	}
+
+	writeJSON(w, http.StatusConflict, &envelope{Error: "edit conflict", Data: current})
+}
+
+func (app *application) payloadTooLargeResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("payload too large", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	writeJSONError(w, http.StatusRequestEntityTooLarge, err.Error())
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("unsupported media type", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	writeJSONError(w, http.StatusUnsupportedMediaType, err.Error())
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"social/internal/store"
+	"strconv"
+	"strings"
+)
+
+// postETag is derived from the version, which every update bumps. It tags the post as the write endpoints
+// return it, without comments and reactions
+func postETag(post *store.Post) string {
+	return `"` + strconv.Itoa(post.Version) + `"`
+}
+
+// postResponseETag tags GET /posts/{id} by its whole body, comments and reactions change without the version
+// and the body has the reactions of the viewer. The version stays in front so the tag works for If-Match
+func postResponseETag(post *store.Post, body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + strconv.Itoa(post.Version) + "-" + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// postIfMatches compares If-Match strongly against both tags of the post. A tag of GET /posts/{id} only needs
+// the same version, new comments or reactions don't make a copy of the post outdated
+func postIfMatches(header string, post *store.Post) bool {
+	if etagMatches(header, postETag(post), false) {
+		return true
+	}
+
+	prefix := `"` + strconv.Itoa(post.Version) + "-"
+	for _, tag := range strings.Split(header, ",") {
+		if strings.HasPrefix(strings.TrimSpace(tag), prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// etagMatches looks for etag in an If-Match or If-None-Match header. If-Match compares strongly, so weak
+// tags never match there. If-None-Match compares weakly and ignores the W/ prefix
+func etagMatches(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPostIfMatch answers 412 when the client changes a post based on an outdated copy. Without an
+// If-Match header the version postsContextMiddleware loaded is used, which still catches concurrent writes
+func (app *application) checkPostIfMatch(w http.ResponseWriter, r *http.Request, post *store.Post) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || postIfMatches(ifMatch, post) {
+		return true
+	}
+
+	w.Header().Set("ETag", postETag(post))
+	app.preconditionFailedResponse(w, r)
+	return false
+}
+
+// postEditConflict is for writes that lost the race against another one, it answers 409 with the post
+// as it is now
+func (app *application) postEditConflict(w http.ResponseWriter, r *http.Request, postID int64) {
+	current, err := app.store.Posts.GetByID(r.Context(), postID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", postETag(current))
+	app.editConflictResponse(w, r, current)
+}
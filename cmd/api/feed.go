@@ -8,7 +8,7 @@ import (
 // getUserFeedHandler godoc
 //
 //	@Summary		Fetches the user feed
-//	@Description	Fetches the user feed
+//	@Description	Fetches the feed of the authenticated user, with reaction counts and their own reactions
 //	@Tags			feed
 //	@Accept			json
 //	@Produce		json
@@ -49,8 +49,9 @@ func (app *application) getUserFeedHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	ctx := r.Context()
+	user := getUserFromContext(r)
 	//pass the feed query fq in GetUserFeed method
-	feed, err := app.store.Posts.GetUserFeed(ctx, int64(12), fq)
+	feed, err := app.store.Posts.GetUserFeed(ctx, user.ID, fq)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
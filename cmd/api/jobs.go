@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// job is maintenance work which runs every interval for as long as the server is up
+type job struct {
+	name     string
+	interval time.Duration
+	run      func(context.Context) error
+}
+
+func (app *application) jobs() []job {
+	jobs := []job{
+		{name: "purge-unactivated-users", interval: app.config.jobs.purgeInterval, run: app.purgeUnactivatedUsers},
+		{name: "purge-ended-sessions", interval: app.config.jobs.purgeInterval, run: app.purgeEndedSessions},
+		{name: "purge-revoked-tokens", interval: app.config.jobs.purgeInterval, run: app.purgeRevokedTokens},
+		{name: "purge-deleted-content", interval: app.config.jobs.purgeInterval, run: app.purgeDeletedContent},
+		{name: "purge-orphaned-media", interval: app.config.jobs.purgeInterval, run: app.purgeOrphanedMedia},
+		{name: "publish-scheduled-posts", interval: app.config.jobs.publishInterval, run: app.publishScheduledPosts},
+		{name: "flush-session-activity", interval: app.config.jobs.sessionFlushInterval, run: app.flushSessionActivity},
+	}
+
+	if app.signingKeys != nil {
+		jobs = append(jobs, job{name: "reload-signing-keys", interval: app.config.auth.token.keysReloadInt, run: app.reloadSigningKeys})
+	}
+
+	return jobs
+}
+
+// startJobs runs every job on its own ticker until ctx is cancelled, run waits for them through app.wg
+// so a shutdown never cuts a job off halfway
+func (app *application) startJobs(ctx context.Context) {
+	for _, j := range app.jobs() {
+		app.wg.Add(1)
+		go func() {
+			defer app.wg.Done()
+
+			ticker := time.NewTicker(j.interval)
+			defer ticker.Stop()
+
+			for {
+				app.runJob(ctx, j)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+}
+
+// runJob runs j once, a failing or panicking job is logged and simply tried again on the next tick
+func (app *application) runJob(ctx context.Context, j job) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.Errorw("job panicked", "job", j.name, "error", fmt.Sprint(err))
+		}
+	}()
+
+	start := time.Now()
+	if err := j.run(ctx); err != nil {
+		app.logger.Errorw("job failed", "job", j.name, "error", err)
+		return
+	}
+
+	app.logger.Infow("job finished", "job", j.name, "duration", time.Since(start).String())
+}
+
+// purgeUnactivatedUsers removes accounts which didn't activate within the grace period, then the
+// invitations nobody can accept anymore
+func (app *application) purgeUnactivatedUsers(ctx context.Context) error {
+	createdBefore := time.Now().Add(-app.config.jobs.unactivatedGrace)
+
+	users, err := app.store.Users.PurgeUnactivated(ctx, createdBefore)
+	if err != nil {
+		return err
+	}
+
+	invitations, err := app.store.Users.PurgeExpiredInvitations(ctx)
+	if err != nil {
+		return err
+	}
+
+	if users > 0 || invitations > 0 {
+		app.logger.Infow("purged unactivated users", "users", users, "invitations", invitations)
+	}
+
+	return nil
+}
+
+// reloadSigningKeys picks up new key files in the keys directory, that's how keys are rotated without a restart
+func (app *application) reloadSigningKeys(ctx context.Context) error {
+	return app.signingKeys.Reload()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"social/internal/auth"
+	"social/internal/store"
+	"time"
+)
+
+// tokenDenylist remembers access tokens which were logged out before their exp. Redis (cache.Storage) and
+// Postgres (store.Storage) both implement it, see app.denylist
+type tokenDenylist interface {
+	Revoke(ctx context.Context, jti string, exp time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	RevokeIssuedBefore(ctx context.Context, userID int64, t time.Time, ttl time.Duration) error
+	IssuedBefore(context.Context, int64) (time.Time, error)
+	RevokeFamily(ctx context.Context, familyID string, ttl time.Duration) error
+	IsFamilyRevoked(ctx context.Context, familyID string) (bool, error)
+}
+
+// denylist is the Redis denylist, or the Postgres one when Redis is disabled
+func (app *application) denylist() tokenDenylist {
+	if app.config.redisCfg.enabled {
+		return app.cacheStorage.Tokens
+	}
+
+	return app.store.RevokedTokens
+}
+
+// checkTokenRevoked returns auth.ErrTokenRevoked for access tokens which were logged out, logged out
+// everywhere, or belong to a revoked refresh token family
+func (app *application) checkTokenRevoked(ctx context.Context, p *auth.Principal) error {
+	denylist := app.denylist()
+
+	//every token we issue has a jti, one without can't be logged out so it isn't accepted
+	if p.TokenID == "" {
+		return auth.ErrTokenRevoked
+	}
+
+	revoked, err := denylist.IsRevoked(ctx, p.TokenID)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return auth.ErrTokenRevoked
+	}
+
+	before, err := denylist.IssuedBefore(ctx, p.UserID)
+	if err != nil {
+		return err
+	}
+	//both have microseconds, so logging back in right away gives a token from after the watermark
+	if !before.IsZero() && p.IssuedAt.Before(before) {
+		return auth.ErrTokenRevoked
+	}
+
+	//revoking the refresh token family (logout, reuse detection) has to stop the access tokens too
+	revoked, err = denylist.IsFamilyRevoked(ctx, p.SessionID)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return auth.ErrTokenRevoked
+	}
+
+	return nil
+}
+
+// revokeFamilyAccess stops the access tokens of a refresh token family which was revoked. They live as long
+// as an access token, so that is how long the denylist has to remember the family
+func (app *application) revokeFamilyAccess(ctx context.Context, familyID string) error {
+	return app.denylist().RevokeFamily(ctx, familyID, app.config.auth.token.exp)
+}
+
+// Logout godoc
+//
+//	@Summary		Logs out
+//	@Description	Revokes the access token used for this request and its refresh token
+//	@Tags			authentication
+//	@Success		204	{string}	string	"Logged out"
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/authentication/logout [post]
+func (app *application) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	principal := getPrincipalFromContext(r)
+	ctx := r.Context()
+
+	//the refresh token can't mint new access tokens anymore
+	if err := app.store.RefreshTokens.RevokeFamily(ctx, principal.SessionID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.revokeFamilyAccess(ctx, principal.SessionID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	//and the access token in hand stops working now instead of at its exp
+	if err := app.denylist().Revoke(ctx, principal.TokenID, principal.ExpiresAt); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	clearSessionCookie(w)
+	app.audit(r, store.AuditEvent{ActorID: principal.UserID, Action: store.AuditLogout})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll godoc
+//
+//	@Summary		Logs out everywhere
+//	@Description	Revokes every access and refresh token of the user, on all devices, and every API key
+//	@Tags			authentication
+//	@Success		204	{string}	string	"Logged out everywhere"
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/authentication/logout/all [post]
+func (app *application) logoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	if err := app.store.RefreshTokens.RevokeAllForUser(ctx, user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	//keys don't expire with the access tokens, one created from a lost device would outlive the logout
+	if err := app.store.APIKeys.RevokeAllForUser(ctx, user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	//no access token issued before now outlives the access token lifetime, so the watermark doesn't either
+	err := app.denylist().RevokeIssuedBefore(ctx, user.ID, time.Now(), app.config.auth.token.exp)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.logger.Infow("user logged out everywhere", "user", user.ID)
+	clearSessionCookie(w)
+	app.audit(r, store.AuditEvent{ActorID: user.ID, Action: store.AuditLogoutAll})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// purgeRevokedTokens removes denylist entries of tokens which expired anyway, Redis expires its own keys
+// but the table has to be cleaned up
+func (app *application) purgeRevokedTokens(ctx context.Context) error {
+	tokens, err := app.store.RevokedTokens.PurgeExpired(ctx)
+	if err != nil {
+		return err
+	}
+
+	if tokens > 0 {
+		app.logger.Infow("purged revoked tokens", "tokens", tokens)
+	}
+
+	return nil
+}
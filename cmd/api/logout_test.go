@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"social/internal/auth"
+	"social/internal/store"
+	"testing"
+	"time"
+)
+
+func TestLogout(t *testing.T) {
+	app := newTestApplication(t, config{})
+	mux := app.mount()
+
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should not allow unauthenticated requests", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/logout", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("should log out the current token", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/logout", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusNoContent, rr.Code)
+
+		//the session is on the denylist, the token in hand can't be used anymore
+		rr = excuteRequest(req, mux)
+		checkResponseCode(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("should log out everywhere", func(t *testing.T) {
+		//start over, the test token was logged out above
+		app.store.RevokedTokens = &store.MockRevokedTokenStore{}
+
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/logout/all", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusNoContent, rr.Code)
+	})
+
+	t.Run("should revoke the api keys when logging out everywhere", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/v1/posts/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-API-Key", "gsk_test")
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusUnauthorized, rr.Code)
+	})
+}
+
+func TestCheckTokenRevoked(t *testing.T) {
+	app := newTestApplication(t, config{})
+
+	loggedOut := time.Now().Truncate(time.Second).Add(500 * time.Millisecond)
+	if err := app.denylist().RevokeIssuedBefore(context.Background(), 7, loggedOut, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		issuedAt time.Time
+		revoked  bool
+	}{
+		{name: "should revoke a token issued before logging out everywhere", issuedAt: loggedOut.Add(-time.Minute), revoked: true},
+		{name: "should revoke a token issued earlier in the same second", issuedAt: loggedOut.Add(-time.Millisecond), revoked: true},
+		{name: "should accept a token issued later in the same second", issuedAt: loggedOut.Add(time.Millisecond), revoked: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &auth.Principal{UserID: 7, TokenID: "jti", SessionID: "session", IssuedAt: tt.issuedAt}
+
+			err := app.checkTokenRevoked(context.Background(), p)
+			if revoked := errors.Is(err, auth.ErrTokenRevoked); revoked != tt.revoked {
+				t.Errorf("expected revoked %v, got %v", tt.revoked, err)
+			}
+		})
+	}
+
+	t.Run("should accept the token of logging back in right after logging out everywhere", func(t *testing.T) {
+		app.authenticator = auth.NewJWTAuthenticator("test", "test-aud", "test-aud")
+		app.config.auth.token.iss = "test-aud"
+		app.config.auth.token.exp = time.Hour
+		extractor := &auth.BearerExtractor{Authenticator: app.authenticator}
+
+		ctx := context.Background()
+		extract := func(token string) *auth.Principal {
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+
+			p, err := extractor.Extract(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return p
+		}
+
+		before, err := app.generateAccessToken(8, "old-session")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := app.denylist().RevokeIssuedBefore(ctx, 8, time.Now(), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		after, err := app.generateAccessToken(8, "new-session")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := app.checkTokenRevoked(ctx, extract(before)); !errors.Is(err, auth.ErrTokenRevoked) {
+			t.Errorf("expected the token from before to be revoked, got %v", err)
+		}
+		if err := app.checkTokenRevoked(ctx, extract(after)); err != nil {
+			t.Errorf("expected the new token to be accepted, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"social/internal/mailer"
+	"social/internal/store"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type MagicLinkPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// RequestMagicLink godoc
+//
+//	@Summary		Emails a login link
+//	@Description	Sends a single use login link to an active account, so no password is needed.
+//	@Description	The response is the same whether such an account exists or not
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		MagicLinkPayload	true	"Account email"
+//	@Success		202		{string}	string				"Login link sent if the account exists"
+//	@Failure		400		{object}	error
+//	@Failure		429		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/magic-link [post]
+func (app *application) requestMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MagicLinkPayload
+	err := readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = Validate.Struct(payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	//limited whether the account exists or not, otherwise the 429 would tell
+	if allow, retryAfter := app.magicLinkLimiter.Allow(strings.ToLower(payload.Email)); !allow {
+		app.rateLimitExceededResponse(w, r, retryAfter.String())
+		return
+	}
+
+	//whatever happens below, the client always gets this answer
+	msg := "if an account exists for that email, a login link has been sent"
+
+	ctx := r.Context()
+
+	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
+	if err != nil && err != store.ErrNotFound {
+		app.internalServerError(w, r, err)
+		return
+	}
+	//accounts which aren't activated have to use their invitation first
+	if err == store.ErrNotFound || !user.IsActive {
+		if err := app.jsonResponse(w, http.StatusAccepted, msg); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	cfg := app.config.auth.magicLink
+
+	//the token, the audit and the email all happen in the background, so a known email answers as fast as
+	//an unknown one
+	app.background(func() {
+		plainToken := uuid.New().String()
+		err := app.store.Users.CreateToken(context.Background(), user.ID, store.ScopeMagicLink, plainToken, cfg.exp)
+		if err != nil {
+			app.logger.Errorw("error creating login link token", "user", user.ID, "error", err)
+			return
+		}
+
+		app.audit(r, store.AuditEvent{Action: store.AuditMagicLinkRequested, TargetType: "user", TargetID: user.ID})
+
+		loginURL := fmt.Sprintf("%s/login/magic/%s", app.config.frontendURL, plainToken)
+
+		isProdEnv := app.config.env == "production"
+		vars := struct {
+			Username string
+			LoginURL string
+			Expiry   string
+		}{
+			Username: user.Username,
+			LoginURL: loginURL,
+			Expiry:   cfg.exp.String(),
+		}
+
+		status, err := app.mailer.Send(mailer.MagicLinkTemplate, user.Username, user.Email, vars, !isProdEnv)
+		if err != nil {
+			app.logger.Errorw("error sending login link", "user", user.ID, "error", err)
+			return
+		}
+
+		app.logger.Infow("Email sent", "status code", status)
+	})
+
+	if err := app.jsonResponse(w, http.StatusAccepted, msg); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// the token goes in the body rather than the path, so it doesn't end up in access logs
+type RedeemMagicLinkPayload struct {
+	Token string `json:"token" validate:"required,max=100"`
+}
+
+// RedeemMagicLink godoc
+//
+//	@Summary		Logs in with a login link
+//	@Description	Exchanges the token of an emailed login link for tokens, like a password login. Users with 2FA
+//	@Description	get a challenge instead
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		RedeemMagicLinkPayload	true	"Login link token"
+//	@Success		201		{object}	TokenResponse			"Tokens"
+//	@Success		200		{object}	MFAChallengeResponse	"Second factor required"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/magic-link/redeem [post]
+func (app *application) redeemMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RedeemMagicLinkPayload
+	err := readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = Validate.Struct(payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	userID, err := app.store.Users.RedeemMagicLink(ctx, payload.Token)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.audit(r, store.AuditEvent{
+				Action:   store.AuditLoginFailed,
+				Metadata: map[string]any{"reason": "invalid_magic_link"},
+			})
+			app.unauthorizedErrorResponse(w, r, errInvalidCredentials)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	//straight from the database, the lockout fields aren't cached
+	user, err := app.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if user.IsLocked() {
+		app.unauthorizedErrorResponse(w, r, fmt.Errorf("account %d is locked", user.ID))
+		return
+	}
+
+	app.completeLogin(w, r, user, "magic_link")
+}
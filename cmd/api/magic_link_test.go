@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"social/internal/mailer"
+	"social/internal/store"
+	"strings"
+	"testing"
+)
+
+func TestMagicLink(t *testing.T) {
+	app := newTestApplication(t, config{})
+	mux := app.mount()
+
+	t.Run("should rate limit requests per email", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			req, err := http.NewRequest(http.MethodPost, "/v1/authentication/magic-link", strings.NewReader(`{"email":"Gopher@example.com"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := excuteRequest(req, mux)
+
+			if i < 3 {
+				checkResponseCode(t, http.StatusAccepted, rr.Code)
+			} else {
+				checkResponseCode(t, http.StatusTooManyRequests, rr.Code)
+			}
+		}
+
+		//another address still gets through
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/magic-link", strings.NewReader(`{"email":"other@example.com"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := excuteRequest(req, mux)
+		checkResponseCode(t, http.StatusAccepted, rr.Code)
+	})
+
+	t.Run("should issue tokens for a valid link", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/magic-link/redeem", strings.NewReader(`{"token":"some-token"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusCreated, rr.Code)
+	})
+
+	t.Run("should require a token", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/magic-link/redeem", strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+	t.Run("should create the token and send the link in the background", func(t *testing.T) {
+		users := app.store.Users.(*store.MockUserStore)
+		users.Email = "magic@example.com"
+		users.Active = true
+		mails := app.mailer.(*mailer.MockClient)
+
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/magic-link", strings.NewReader(`{"email":"magic@example.com"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+		checkResponseCode(t, http.StatusAccepted, rr.Code)
+
+		app.wg.Wait()
+		if users.Token(store.ScopeMagicLink) == "" {
+			t.Error("expected a login link token")
+		}
+		if n := mails.Count(mailer.MagicLinkTemplate); n != 1 {
+			t.Errorf("expected 1 login link email, got %d", n)
+		}
+	})
+}
@@ -5,12 +5,14 @@ import (
 	"log"
 	"runtime"
 	"social/internal/auth"
+	"social/internal/blob"
 	"social/internal/db"
 	"social/internal/env"
 	"social/internal/mailer"
 	"social/internal/ratelimiter"
 	"social/internal/store"
 	"social/internal/store/cache"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -63,12 +65,14 @@ func main() {
 		env: env.GetString("ENV", "development"),
 		mail: mailConfig{
 			exp:       time.Hour * 24 * 3, //3 days to accept invitation
+			resetExp:  time.Hour,          //password reset links are short lived
 			fromEmail: env.GetString("FROM_EMAIL", ""),
 			sendGrid: sendGridConfig{
 				apiKey: env.GetString("SENDGRID_API_KEY", ""),
 			},
 		},
 		auth: authConfig{
+			methods: strings.Split(env.GetString("AUTH_METHODS", "bearer,api_key"), ","),
 			//ex 50
 			basic: basicConfig{
 				user: env.GetString("AUTH_BASIC_USER", "admin"),
@@ -77,8 +81,47 @@ func main() {
 			//ex 51
 			token: tokenConfig{
 				secret: env.GetString("AUTH_TOKEN_SECRET", "example"),
-				exp:    time.Hour * 24 * 3, //3 days
-				iss:    "gophersocial",
+				//access tokens are short lived, clients use the refresh token to get a new one
+				exp:        time.Minute * 15,
+				refreshExp: time.Hour * 24 * 30, //30 days
+				iss:        "gophersocial",
+
+				alg:           env.GetString("AUTH_TOKEN_ALG", "HS256"),
+				keysDir:       env.GetString("AUTH_TOKEN_KEYS_DIR", "./keys"),
+				keysReloadInt: time.Minute * 5,
+			},
+			lockout: lockoutConfig{
+				maxAttempts: env.GetInt("AUTH_LOCKOUT_MAX_ATTEMPTS", 5),
+				duration:    time.Minute * 15,
+			},
+			mfa: mfaConfig{
+				challengeExp: time.Minute * 5,
+				issuer:       "GopherSocial",
+				secretKey:    env.GetString("AUTH_MFA_SECRET_KEY", "example"),
+				//comma separated role names, e.g. "moderator,admin"
+				requiredRoles: env.GetList("AUTH_MFA_REQUIRED_ROLES", ""),
+			},
+			oidc: oidcConfig{
+				providers: loadOIDCConfigs(),
+				stateExp:  time.Minute * 10,
+			},
+			magicLink: magicLinkConfig{
+				exp:               time.Minute * 15,
+				requestsPerWindow: env.GetInt("AUTH_MAGIC_LINK_REQUESTS", 3),
+				window:            time.Minute * 15,
+			},
+			password: passwordConfig{
+				minLength:    env.GetInt("AUTH_PASSWORD_MIN_LENGTH", 8),
+				maxLength:    128,
+				breachedFile: env.GetString("AUTH_BREACHED_PASSWORDS_FILE", ""),
+				//changing these upgrades every hash on the user's next login
+				argon2: store.Argon2Params{
+					Memory:      uint32(env.GetInt("AUTH_ARGON2_MEMORY_KB", 64*1024)),
+					Iterations:  uint32(env.GetInt("AUTH_ARGON2_ITERATIONS", 3)),
+					Parallelism: uint8(env.GetInt("AUTH_ARGON2_PARALLELISM", 2)),
+					SaltLength:  16,
+					KeyLength:   32,
+				},
 			},
 		},
 		//ex65 rate limiter
@@ -87,6 +130,27 @@ func main() {
 			TimeFrame:            time.Second * 5,
 			Enabled:              env.GetBool("RATE_LIMITER_ENABLED", true),
 		},
+		jobs: jobsConfig{
+			purgeInterval:    time.Hour,
+			unactivatedGrace: time.Hour * 24 * time.Duration(env.GetInt("USERS_UNACTIVATED_GRACE_DAYS", 7)),
+
+			sessionFlushInterval: time.Minute,
+			deletedRetention:     time.Hour * 24 * time.Duration(env.GetInt("DELETED_RETENTION_DAYS", 30)),
+			publishInterval:      time.Second * time.Duration(env.GetInt("POSTS_PUBLISH_INTERVAL_SECONDS", 30)),
+		},
+		audit: auditConfig{
+			bufferSize:    1000,
+			batchSize:     100,
+			flushInterval: time.Second,
+		},
+		reactions: reactionsConfig{
+			kinds: env.GetList("REACTION_KINDS", "like,love,laugh,wow,sad,angry"),
+		},
+		media: mediaConfig{
+			dir:            env.GetString("MEDIA_DIR", "./uploads"),
+			maxUploadBytes: int64(env.GetInt("MEDIA_MAX_UPLOAD_MB", 10)) << 20,
+			orphanTTL:      time.Hour * time.Duration(env.GetInt("MEDIA_ORPHAN_HOURS", 24)),
+		},
 	}
 
 	//Logger
@@ -121,23 +185,64 @@ func main() {
 		cfg.rateLimiter.TimeFrame,
 	)
 
-	store := store.NewStorage(db)
+	//new hashes use the configured cost, older ones are upgraded on login
+	store := store.NewStorage(db, cfg.auth.password.argon2, cfg.auth.mfa.secretKey)
 	cacheStorage := cache.NewRedisStorage(rdb)
 
 	//ex 46
 	mailer := mailer.NewSendGrid(cfg.mail.sendGrid.apiKey, cfg.mail.fromEmail)
 
+	//S3 compatible stores can implement blob.Store as well, for now uploads are files
+	blobs, err := blob.NewLocalStore(cfg.media.dir)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
 	//ex 51
 	jwtAuthenticator := auth.NewJWTAuthenticator(cfg.auth.token.secret, cfg.auth.token.iss, cfg.auth.token.iss)
+	var signingKeys *auth.KeySet
+	if cfg.auth.token.alg != "HS256" {
+		signingKeys, err = auth.LoadKeySet(cfg.auth.token.keysDir, cfg.auth.token.alg)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		logger.Infow("signing keys loaded", "alg", cfg.auth.token.alg, "dir", cfg.auth.token.keysDir)
+
+		jwtAuthenticator = auth.NewKeySetAuthenticator(signingKeys, cfg.auth.token.iss, cfg.auth.token.iss)
+	}
 
+	oidcProviders := make(map[string]*auth.OIDCProvider, len(cfg.auth.oidc.providers))
+	for _, providerCfg := range cfg.auth.oidc.providers {
+		//discovery happens on the first login, a provider being down doesn't stop the API from starting
+		oidcProviders[providerCfg.Name] = auth.NewOIDCProvider(providerCfg, nil)
+		logger.Infow("oidc provider configured", "provider", providerCfg.Name, "issuer", providerCfg.IssuerURL)
+	}
+
+	passwordPolicy, err := auth.NewPasswordPolicy(cfg.auth.password.minLength, cfg.auth.password.maxLength, cfg.auth.password.breachedFile)
+	if err != nil {
+		logger.Fatal(err)
+	}
 	app := &application{
-		config:        cfg,
-		store:         store,
-		cacheStorage:  cacheStorage,
-		logger:        logger,
-		mailer:        mailer,
-		authenticator: jwtAuthenticator,
-		rateLimiter:   rateLimiter,
+		config:         cfg,
+		store:          store,
+		cacheStorage:   cacheStorage,
+		logger:         logger,
+		mailer:         mailer,
+		blobs:          blobs,
+		signingKeys:    signingKeys,
+		authenticator:  jwtAuthenticator,
+		rateLimiter:    rateLimiter,
+		oidcProviders:  oidcProviders,
+		passwordPolicy: passwordPolicy,
+		magicLinkLimiter: ratelimiter.NewFixedWindowRateLimiter(
+			cfg.auth.magicLink.requestsPerWindow,
+			cfg.auth.magicLink.window,
+		),
+	}
+
+	app.authChain, err = app.newAuthChain(cfg.auth.methods)
+	if err != nil {
+		logger.Fatal(err)
 	}
 
 	//Metrics collected
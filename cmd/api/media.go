@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"social/internal/blob"
+	"social/internal/store"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type mediaKey string
+
+const mediaCtx mediaKey = "media"
+
+const (
+	//longest side of a thumbnail in pixels
+	thumbnailSize = 320
+	//decoding takes 4 bytes per pixel and more, a small file can still be a huge image
+	maxImagePixels = 25_000_000
+)
+
+// the types we can decode for thumbnails, sniffed from the bytes and not taken from the client
+var imageContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+var (
+	errMissingFile      = errors.New("the image has to be sent as the file field")
+	errImageTooLarge    = errors.New("the image is too large")
+	errUnsupportedImage = errors.New("only jpeg, png and gif images are supported")
+)
+
+// UploadMedia godoc
+//
+//	@Summary		Uploads an image
+//	@Description	Uploads an image to attach to a post with media_ids when creating it. Uploads that aren't
+//	@Description	attached within the orphan window are deleted
+//	@Tags			media
+//	@Accept			mpfd
+//	@Produce		json
+//	@Param			file	formData	file	true	"JPEG, PNG or GIF image"
+//	@Success		201		{object}	store.Media
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		413		{object}	error
+//	@Failure		415		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/media [post]
+func (app *application) uploadMediaHandler(w http.ResponseWriter, r *http.Request) {
+	maxBytes := app.config.media.maxUploadBytes
+	//room for the multipart boundaries and headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+1<<20)
+
+	data, err := readMultipartFile(r, "file", maxBytes)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.Is(err, errImageTooLarge), errors.As(err, &maxBytesErr):
+			app.payloadTooLargeResponse(w, r, errImageTooLarge)
+		default:
+			app.badRequestError(w, r, err)
+		}
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	if !imageContentTypes[contentType] {
+		app.unsupportedMediaTypeResponse(w, r, errUnsupportedImage)
+		return
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		app.unsupportedMediaTypeResponse(w, r, errUnsupportedImage)
+		return
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		app.payloadTooLargeResponse(w, r, errImageTooLarge)
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		app.unsupportedMediaTypeResponse(w, r, errUnsupportedImage)
+		return
+	}
+
+	var thumbnail bytes.Buffer
+	if err := jpeg.Encode(&thumbnail, newThumbnail(img, thumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	key := "media/" + uuid.New().String()
+	media := &store.Media{
+		UserID:       getUserFromContext(r).ID,
+		Key:          key,
+		ThumbnailKey: key + "_thumb",
+		ContentType:  contentType,
+		Size:         int64(len(data)),
+		Width:        cfg.Width,
+		Height:       cfg.Height,
+	}
+
+	ctx := r.Context()
+
+	if err := app.storeMedia(ctx, media, data, thumbnail.Bytes()); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, media); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// readMultipartFile reads the part called name, streaming so a big upload is never buffered twice
+func readMultipartFile(r *http.Request, name string, maxBytes int64) ([]byte, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errMissingFile
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() != name {
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, maxBytes+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > maxBytes {
+			return nil, errImageTooLarge
+		}
+
+		return data, nil
+	}
+}
+
+// storeMedia puts both blobs before the row, a failed upload leaves nothing behind
+func (app *application) storeMedia(ctx context.Context, media *store.Media, data, thumbnail []byte) error {
+	err := app.blobs.Put(ctx, media.Key, bytes.NewReader(data))
+	if err == nil {
+		err = app.blobs.Put(ctx, media.ThumbnailKey, bytes.NewReader(thumbnail))
+	}
+	if err == nil {
+		err = app.store.Media.Create(ctx, media)
+	}
+
+	if err != nil {
+		app.deleteBlobs(ctx, media.Key, media.ThumbnailKey)
+		return err
+	}
+
+	return nil
+}
+
+// newThumbnail scales img down to fit into size x size, averaging the pixels each thumbnail pixel covers.
+// Thumbnails are JPEGs, transparent parts end up white
+func newThumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, max(1, h*size/w)
+		} else {
+			tw, th = max(1, w*size/h), size
+		}
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for ty := 0; ty < th; ty++ {
+		y0, y1 := b.Min.Y+ty*h/th, b.Min.Y+max((ty+1)*h/th, ty*h/th+1)
+		for tx := 0; tx < tw; tx++ {
+			x0, x1 := b.Min.X+tx*w/tw, b.Min.X+max((tx+1)*w/tw, tx*w/tw+1)
+
+			var sr, sg, sb, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					//premultiplied, adding what is missing to full alpha puts the pixel on white
+					r, g, b, a := img.At(x, y).RGBA()
+					sr += uint64(r + 0xffff - a)
+					sg += uint64(g + 0xffff - a)
+					sb += uint64(b + 0xffff - a)
+					n++
+				}
+			}
+
+			thumb.SetRGBA(tx, ty, color.RGBA{
+				R: uint8(sr / n >> 8),
+				G: uint8(sg / n >> 8),
+				B: uint8(sb / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	return thumb
+}
+
+// GetMedia godoc
+//
+//	@Summary		Fetches an image
+//	@Description	Fetches an uploaded image as it was uploaded. Images of posts are visible to whoever can see
+//	@Description	the post, unattached uploads only to the uploader
+//	@Tags			media
+//	@Produce		image/jpeg,image/png,image/gif
+//	@Param			mediaID	path		int		true	"Media ID"
+//	@Success		200		{file}		file	"The image"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/media/{mediaID} [get]
+func (app *application) getMediaHandler(w http.ResponseWriter, r *http.Request) {
+	media := getMediaFromCtx(r)
+	app.serveBlob(w, r, media.Key, media.ContentType)
+}
+
+// GetMediaThumbnail godoc
+//
+//	@Summary		Fetches the thumbnail of an image
+//	@Description	Fetches a JPEG of at most 320x320 pixels, visible to the same users as the image
+//	@Tags			media
+//	@Produce		image/jpeg
+//	@Param			mediaID	path		int		true	"Media ID"
+//	@Success		200		{file}		file	"The thumbnail"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/media/{mediaID}/thumbnail [get]
+func (app *application) getMediaThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	media := getMediaFromCtx(r)
+	app.serveBlob(w, r, media.ThumbnailKey, "image/jpeg")
+}
+
+func (app *application) serveBlob(w http.ResponseWriter, r *http.Request, key, contentType string) {
+	f, err := app.blobs.Open(r.Context(), key)
+	if err != nil {
+		switch err {
+		case blob.ErrNotFound:
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	//a key is never reused for other bytes, but who may see them can change
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+
+	if _, err := io.Copy(w, f); err != nil {
+		app.logger.Warnw("error serving blob", "key", key, "error", err)
+	}
+}
+
+// mediaContextMiddleware loads the media and checks the authenticated user may see it, the same way
+// postsContextMiddleware does for the post it is attached to
+func (app *application) mediaContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "mediaID"), 10, 64)
+		if err != nil {
+			app.badRequestError(w, r, err)
+			return
+		}
+
+		ctx := r.Context()
+		user := getUserFromContext(r)
+
+		media, err := app.store.Media.GetByID(ctx, id)
+		if err != nil {
+			switch err {
+			case store.ErrNotFound:
+				app.notFoundError(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		if media.PostID == nil {
+			if media.UserID != user.ID {
+				app.notFoundError(w, r, store.ErrNotFound)
+				return
+			}
+		} else {
+			//deleted posts and posts of deleted users are not found either
+			post, err := app.store.Posts.GetByID(ctx, *media.PostID)
+			if err != nil {
+				switch err {
+				case store.ErrNotFound:
+					app.notFoundError(w, r, err)
+				default:
+					app.internalServerError(w, r, err)
+				}
+				return
+			}
+			if post.Draft && post.UserID != user.ID {
+				app.notFoundError(w, r, store.ErrNotFound)
+				return
+			}
+		}
+
+		ctx = context.WithValue(ctx, mediaCtx, media)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getMediaFromCtx(r *http.Request) *store.Media {
+	media, _ := r.Context().Value(mediaCtx).(*store.Media)
+	return media
+}
+
+// deleteBlobs is best effort, a blob that couldn't be deleted is logged and left behind
+func (app *application) deleteBlobs(ctx context.Context, keys ...string) int {
+	failed := 0
+	for _, key := range keys {
+		if err := app.blobs.Delete(ctx, key); err != nil {
+			app.logger.Errorw("error deleting blob", "key", key, "error", err)
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// purgeOrphanedMedia deletes uploads nobody attached to a post within the orphan window, media of purged
+// posts end up here as well
+func (app *application) purgeOrphanedMedia(ctx context.Context) error {
+	media, err := app.store.Media.DeleteOrphaned(ctx, time.Now().Add(-app.config.media.orphanTTL))
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, m := range media {
+		failed += app.deleteBlobs(ctx, m.Key, m.ThumbnailKey)
+	}
+
+	if len(media) > 0 {
+		app.logger.Infow("purged orphaned media", "media", len(media), "failed_blobs", failed)
+	}
+
+	return nil
+}
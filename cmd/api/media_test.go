@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"social/internal/store"
+	"strings"
+	"testing"
+)
+
+func TestMedia(t *testing.T) {
+	app := newTestApplication(t, config{})
+	app.config.media.maxUploadBytes = 1 << 20
+	mux := app.mount()
+
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(field string, data []byte) *http.Request {
+		t.Helper()
+
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, err := mw.CreateFormFile(field, "upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+		mw.Close()
+
+		req, err := http.NewRequest(http.MethodPost, "/v1/media", &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req
+	}
+
+	get := func(path string) *http.Request {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		return req
+	}
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 600, 300))); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should upload an image", func(t *testing.T) {
+		rr := excuteRequest(upload("file", img.Bytes()), mux)
+		checkResponseCode(t, http.StatusCreated, rr.Code)
+	})
+
+	t.Run("should reject uploads that are not images", func(t *testing.T) {
+		rr := excuteRequest(upload("file", []byte("<html><script>alert(1)</script></html>")), mux)
+		checkResponseCode(t, http.StatusUnsupportedMediaType, rr.Code)
+
+		rr = excuteRequest(upload("image", img.Bytes()), mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("should reject uploads over the size limit", func(t *testing.T) {
+		app.config.media.maxUploadBytes = 100
+		defer func() { app.config.media.maxUploadBytes = 1 << 20 }()
+
+		rr := excuteRequest(upload("file", img.Bytes()), mux)
+		checkResponseCode(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+
+	t.Run("should serve uploads to the uploader only", func(t *testing.T) {
+		ctx := context.Background()
+		if err := app.blobs.Put(ctx, "media/test", bytes.NewReader(img.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		if err := app.blobs.Put(ctx, "media/test_thumb", strings.NewReader("thumbnail")); err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(get("/v1/media/1"), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+		if ct := rr.Header().Get("Content-Type"); ct != "image/png" {
+			t.Errorf("expected image/png, got %q", ct)
+		}
+
+		rr = excuteRequest(get("/v1/media/1/thumbnail"), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+
+		app.store.Media = &store.MockMediaStore{OwnerID: 2}
+		defer func() { app.store.Media = &store.MockMediaStore{} }()
+
+		rr = excuteRequest(get("/v1/media/1"), mux)
+		//notFoundError answers with 400
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("should not attach the same media twice", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "/v1/posts", strings.NewReader(`{"title": "t", "content": "c", "media_ids": [1, 1]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("should purge orphaned media", func(t *testing.T) {
+		if err := app.purgeOrphanedMedia(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		w, h   int
+		tw, th int
+	}{
+		{600, 300, 320, 160},
+		{300, 1200, 80, 320},
+		{100, 50, 100, 50},
+		{5000, 1, 320, 1},
+	}
+
+	for _, tt := range tests {
+		thumb := newThumbnail(image.NewRGBA(image.Rect(0, 0, tt.w, tt.h)), thumbnailSize)
+		if b := thumb.Bounds(); b.Dx() != tt.tw || b.Dy() != tt.th {
+			t.Errorf("%dx%d: expected %dx%d, got %dx%d", tt.w, tt.h, tt.tw, tt.th, b.Dx(), b.Dy())
+		}
+	}
+
+	//a transparent image comes out white
+	if c := newThumbnail(image.NewRGBA(image.Rect(0, 0, 10, 10)), thumbnailSize).At(0, 0); c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("expected white, got %v", c)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"social/internal/auth"
+	"social/internal/store"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	mfaTokenType      = "mfa"
+	recoveryCodeCount = 10
+)
+
+var errInvalidMFACode = errors.New("invalid two-factor code")
+
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// generateMFAChallenge signs a short lived token which only proves the password step, the typ claim
+// keeps AuthTokenMiddleware from accepting it as an access token
+func (app *application) generateMFAChallenge(userID int64) (*MFAChallengeResponse, error) {
+	exp := app.config.auth.mfa.challengeExp
+
+	claims := jwt.MapClaims{
+		"sub": userID,
+		"typ": mfaTokenType,
+		"sid": uuid.New().String(),
+		"jti": uuid.New().String(),
+		"exp": time.Now().Add(exp).Unix(),
+		"iat": time.Now().Unix(),
+		"nbf": time.Now().Unix(),
+		"iss": app.config.auth.token.iss,
+		"aud": app.config.auth.token.iss,
+	}
+
+	token, err := app.authenticator.GenerateToken(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int64(exp.Seconds()),
+	}, nil
+}
+
+// mfaEnrollmentRequired is true for users whose role is forced into 2FA but who haven't enrolled yet
+func (app *application) mfaEnrollmentRequired(ctx context.Context, user *store.User) (bool, error) {
+	if user.MFAEnabled {
+		return false, nil
+	}
+
+	return app.mfaRequired(ctx, user)
+}
+
+// mfaRequired is true if the user's role is listed in AUTH_MFA_REQUIRED_ROLES or granted auth.mfa.required
+func (app *application) mfaRequired(ctx context.Context, user *store.User) (bool, error) {
+	if slices.Contains(app.config.auth.mfa.requiredRoles, user.Role.Name) {
+		return true, nil
+	}
+
+	return app.hasPermission(ctx, user, store.PermMFARequired)
+}
+
+type VerifyMFAPayload struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required,max=32"`
+}
+
+// VerifyMFA godoc
+//
+//	@Summary		Completes a two-factor login
+//	@Description	Exchanges the mfa_token from /authentication/token and a TOTP or recovery code for tokens
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		VerifyMFAPayload	true	"Challenge and code"
+//	@Success		201		{object}	TokenResponse		"Tokens"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/mfa [post]
+func (app *application) verifyMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var payload VerifyMFAPayload
+	err := readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = Validate.Struct(payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	jwtToken, err := app.authenticator.ValidateToken(payload.MFAToken)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	claims, _ := jwtToken.Claims.(jwt.MapClaims)
+	if typ, _ := claims["typ"].(string); typ != mfaTokenType {
+		app.unauthorizedErrorResponse(w, r, fmt.Errorf("not an mfa token"))
+		return
+	}
+
+	userID, err := auth.UserIDFromClaims(claims)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	//straight from the database, the lockout fields aren't cached
+	user, err := app.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if user.IsLocked() {
+		app.unauthorizedErrorResponse(w, r, fmt.Errorf("account %d is locked", user.ID))
+		return
+	}
+
+	err = app.checkSecondFactor(ctx, user.ID, payload.Code)
+	if err != nil {
+		switch err {
+		case errInvalidMFACode:
+			//guessing codes counts towards the same lockout as guessing passwords
+			app.recordFailedLogin(r, user, "wrong_mfa_code")
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+		if err := app.store.Users.ResetFailedLogins(ctx, user.ID); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	tokens, err := app.issueTokens(r, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, store.AuditEvent{
+		ActorID:  user.ID,
+		Action:   store.AuditLogin,
+		Metadata: map[string]any{"mfa": true},
+	})
+
+	app.setSessionCookie(w, tokens)
+
+	if err := app.jsonResponse(w, http.StatusCreated, tokens); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// checkSecondFactor accepts a current TOTP code or one of the unused recovery codes.
+// It returns errInvalidMFACode for anything that doesn't check out, including a replayed TOTP code
+func (app *application) checkSecondFactor(ctx context.Context, userID int64, code string) error {
+	totp, err := app.store.MFA.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !totp.Enabled {
+		return errInvalidMFACode
+	}
+
+	if step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now()); ok {
+		err := app.store.MFA.UseStep(ctx, userID, step)
+		if err != nil {
+			switch err {
+			case store.ErrConflict:
+				return errInvalidMFACode
+			default:
+				return err
+			}
+		}
+		return nil
+	}
+
+	err = app.store.MFA.UseRecoveryCode(ctx, userID, code)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return errInvalidMFACode
+		default:
+			return err
+		}
+	}
+
+	app.logger.Infow("recovery code used", "user", userID)
+	return nil
+}
+
+type TOTPEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+// EnrollTOTP godoc
+//
+//	@Summary		Starts TOTP enrollment
+//	@Description	Generates a new TOTP secret, the otpauth URI can be shown as a QR code. 2FA is only enabled after confirming a code
+//	@Tags			users
+//	@Produce		json
+//	@Success		201	{object}	TOTPEnrollment
+//	@Failure		409	{object}	error	"2FA already enabled"
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/mfa/totp [post]
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	err = app.store.MFA.SetPendingSecret(r.Context(), user.ID, secret)
+	if err != nil {
+		switch err {
+		case store.ErrConflict:
+			app.conflictResponse(w, r, errors.New("two-factor authentication is already enabled"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	enrollment := TOTPEnrollment{
+		Secret:     secret,
+		OtpauthURI: auth.TOTPURI(app.config.auth.mfa.issuer, user.Email, secret),
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, enrollment); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type TOTPCodePayload struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// ConfirmTOTP godoc
+//
+//	@Summary		Confirms TOTP enrollment
+//	@Description	Enables 2FA with a code from the authenticator app and returns one time recovery codes, they are not shown again
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		TOTPCodePayload	true	"Code from the authenticator app"
+//	@Success		200		{object}	RecoveryCodesResponse
+//	@Failure		400		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/mfa/totp/confirm [post]
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	var payload TOTPCodePayload
+	err := readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = Validate.Struct(payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	totp, err := app.store.MFA.Get(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if totp.Enabled || totp.Secret == "" {
+		app.conflictResponse(w, r, errors.New("no pending two-factor enrollment"))
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, payload.Code, time.Now())
+	if !ok {
+		app.badRequestError(w, r, errInvalidMFACode)
+		return
+	}
+
+	codes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	err = app.store.MFA.Enable(ctx, user.ID, step, codes)
+	if err != nil {
+		switch err {
+		case store.ErrConflict:
+			app.conflictResponse(w, r, errors.New("no pending two-factor enrollment"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	//the cached user still says mfa_enabled false
+	app.invalidateUserCache(ctx, user.ID)
+
+	app.audit(r, store.AuditEvent{ActorID: user.ID, Action: store.AuditMFAEnabled, TargetType: "user", TargetID: user.ID})
+
+	if err := app.jsonResponse(w, http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// DisableTOTP godoc
+//
+//	@Summary		Disables TOTP
+//	@Description	Turns 2FA off after checking a current code or a recovery code
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		TOTPCodePayload	true	"Current code"
+//	@Success		204		{string}	string			"2FA disabled"
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error	"2FA is mandatory for the role"
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/mfa/totp [delete]
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	required, err := app.mfaRequired(r.Context(), user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if required {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	var payload TOTPCodePayload
+	err = readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = Validate.Struct(payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = app.checkSecondFactor(r.Context(), user.ID, payload.Code)
+	if err != nil {
+		switch err {
+		case errInvalidMFACode:
+			app.badRequestError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.MFA.Disable(r.Context(), user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.invalidateUserCache(r.Context(), user.ID)
+
+	app.audit(r, store.AuditEvent{ActorID: user.ID, Action: store.AuditMFADisabled, TargetType: "user", TargetID: user.ID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
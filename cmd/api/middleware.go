@@ -3,50 +3,54 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"social/internal/auth"
 	"social/internal/store"
-	"strconv"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 // ex 52, middleware to plug into routers for validating tokens
+// The credentials are found by app.authChain, the methods configured in AUTH_METHODS in that order
 func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		//read the auth header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			app.unauthorizedErrorResponse(w, r, fmt.Errorf("authorization header is missing"))
-			return
-		}
-
-		//parse it -> get the base64
-		parts := strings.Split(authHeader, " ")
-		//Authorization: Bearer HEWRKkkjasdjfjh; last part is the token, so lets write validation
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			app.unauthorizedErrorResponse(w, r, fmt.Errorf("authorization header is malformed"))
-			return
-		}
-		//This token is a jwt token string
-		token := parts[1]
-		jwtToken, err := app.authenticator.ValidateToken(token)
+		principal, err := app.authChain.Authenticate(r)
 		if err != nil {
-			app.unauthorizedErrorResponse(w, r, err)
+			switch {
+			case errors.Is(err, auth.ErrNoCredentials):
+				app.unauthorizedErrorResponse(w, r, fmt.Errorf("authorization header is missing"))
+			case errors.Is(err, auth.ErrInvalidCredentials):
+				app.unauthorizedErrorResponse(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
 			return
 		}
 
-		claims, _ := jwtToken.Claims.(jwt.MapClaims)
+		ctx := r.Context()
 
-		userID, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
-		if err != nil {
-			app.unauthorizedErrorResponse(w, r, err)
-			return
+		//access tokens can be logged out before they expire
+		if principal.Method == auth.MethodBearer || principal.Method == auth.MethodSessionCookie {
+			err = app.checkTokenRevoked(ctx, principal)
+			if err != nil {
+				switch err {
+				case auth.ErrTokenRevoked:
+					app.unauthorizedErrorResponse(w, r, err)
+				default:
+					app.internalServerError(w, r, err)
+				}
+				return
+			}
+		}
+
+		if principal.HasSession() {
+			app.sessionActivity.touch(principal.SessionID)
 		}
 
-		ctx := r.Context()
 		//ex 59, we fetch the user profile for every authenticated user request , this is right place to cache the performance of the user
 		//instead of doing it from the getUserHandler method. so lets implement cache on this layer.
 		//Lets create a function for cache which will abstract this way for a consumer
@@ -55,14 +59,26 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 		// 	app.unauthorizedErrorResponse(w, r, err)
 		// 	return
 		// }
-		user, err := app.getUser(ctx, userID)
+		user, err := app.getUser(ctx, principal.UserID)
 		if err != nil {
 			app.unauthorizedErrorResponse(w, r, err)
 			return
 		}
 
+		//roles which are forced into 2FA can't do anything but enroll until they did
+		enroll, err := app.mfaEnrollmentRequired(ctx, user)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if enroll && !strings.HasPrefix(r.URL.Path, "/v1/users/me/mfa/") {
+			app.mfaEnrollmentRequiredResponse(w, r)
+			return
+		}
+
 		//now lets set the user variable into the context by creating a new context
 		ctx = context.WithValue(ctx, userCtx, user)
+		ctx = context.WithValue(ctx, principalCtx, principal)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -111,26 +127,117 @@ func (app *application) BasicAuthMiddleware() func(http.Handler) http.Handler {
 }
 
 // ex 56 This is authorization for the posts
-func (app *application) checkPostOwnership(requiredRole string, next http.HandlerFunc) http.HandlerFunc {
+// owners can always change their own posts, everybody else needs permission (e.g. posts.update.any)
+func (app *application) checkPostOwnership(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return app.checkOwnership(permission, next, func(r *http.Request) ownedResource {
+		post := getPostFromCtx(r)
+		return ownedResource{kind: "post", id: post.ID, ownerID: post.UserID, action: store.AuditPostModerated}
+	})
+}
+
+// checkCommentOwnership is checkPostOwnership for the comment in the context
+func (app *application) checkCommentOwnership(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return app.checkOwnership(permission, next, func(r *http.Request) ownedResource {
+		comment := getCommentFromCtx(r)
+		return ownedResource{kind: "comment", id: comment.ID, ownerID: comment.UserID, action: store.AuditCommentModerated}
+	})
+}
+
+// ownedResource is what checkOwnership needs to know about the thing being changed
+type ownedResource struct {
+	kind    string
+	id      int64
+	ownerID int64
+	//recorded when somebody other than the owner changed it
+	action string
+}
+
+func (app *application) checkOwnership(permission string, next http.HandlerFunc, resource func(*http.Request) ownedResource) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		//getting authenticated user
 		user := getUserFromContext(r)
-		post := getPostFromCtx(r)
+		res := resource(r)
 
-		//if it is the users post, if user is owner of post then he can go to update/delete handler
-		if post.UserID == user.ID {
+		//if user is the owner then he can go to update/delete handler
+		if res.ownerID == user.ID {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		//role precedence check
-		allowed, err := app.checkRolePrecedence(r.Context(), user, requiredRole)
+		allowed, err := app.hasPermission(r.Context(), user, permission)
 		if err != nil {
 			app.internalServerError(w, r, err)
 			return
 		}
 		//if allowed is false then it should not allow the user to do operations, so forbiddenResponse
 		if !allowed {
+			app.auditPermissionDenied(r, user, permission)
+			app.forbiddenResponse(w, r)
+			return
+		}
+
+		//reading somebody else's isn't moderating it
+		if r.Method == http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		//somebody else's, only recorded when the change actually went through
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		if ww.Status() < http.StatusBadRequest {
+			app.audit(r, store.AuditEvent{
+				ActorID:    user.ID,
+				Action:     res.action,
+				TargetType: res.kind,
+				TargetID:   res.id,
+				Metadata:   map[string]any{"method": r.Method, "permission": permission, "owner_id": res.ownerID},
+			})
+		}
+	})
+}
+
+// RequirePermission only lets users through whose role was granted permission, it goes after AuthTokenMiddleware
+func (app *application) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := getUserFromContext(r)
+			allowed, err := app.hasPermission(r.Context(), user, permission)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+			if !allowed {
+				app.auditPermissionDenied(r, user, permission)
+				app.forbiddenResponse(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireScope stops API keys which weren't granted scope, user sessions always pass
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !getPrincipalFromContext(r).HasScope(scope) {
+				app.insufficientScopeResponse(w, r, scope)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireSession is for account management (API keys, 2FA), which a leaked API key must never reach.
+// Basic auth doesn't get there either, it has no session to log out of or manage
+func (app *application) requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !getPrincipalFromContext(r).HasSession() {
 			app.forbiddenResponse(w, r)
 			return
 		}
@@ -139,15 +246,49 @@ func (app *application) checkPostOwnership(requiredRole string, next http.Handle
 	})
 }
 
-// ex 56
-func (app *application) checkRolePrecedence(ctx context.Context, user *store.User, roleName string) (bool, error) {
-	//fetch the role
-	role, err := app.store.Roles.GetByName(ctx, roleName)
+// auditPermissionDenied records users trying something their role doesn't allow
+func (app *application) auditPermissionDenied(r *http.Request, user *store.User, permission string) {
+	app.audit(r, store.AuditEvent{
+		ActorID:  user.ID,
+		Action:   store.AuditPermissionDenied,
+		Metadata: map[string]any{"permission": permission, "method": r.Method, "path": r.URL.Path},
+	})
+}
+
+// hasPermission checks the permission set of the user's role
+func (app *application) hasPermission(ctx context.Context, user *store.User, permission string) (bool, error) {
+	permissions, err := app.getRolePermissions(ctx, user.Role.ID)
 	if err != nil {
 		return false, err
 	}
-	//We will add Role in userModels, this will check if passed roleName Moderator/admin is greater less/greater than user role
-	return user.Role.Level >= role.Level, nil
+
+	return slices.Contains(permissions, permission), nil
+}
+
+// getRolePermissions is cached like getUser, every authorized request needs it
+func (app *application) getRolePermissions(ctx context.Context, roleID int64) ([]string, error) {
+	if !app.config.redisCfg.enabled {
+		return app.store.Roles.GetPermissions(ctx, roleID)
+	}
+
+	permissions, err := app.cacheStorage.Roles.GetPermissions(ctx, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if permissions == nil {
+		permissions, err = app.store.Roles.GetPermissions(ctx, roleID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = app.cacheStorage.Roles.SetPermissions(ctx, roleID, permissions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return permissions, nil
 }
 
 // ex 59
@@ -180,6 +321,18 @@ func (app *application) getUser(ctx context.Context, userID int64) (*store.User,
 	return user, nil
 }
 
+// invalidateUserCache drops the cached copy of the user after a change it carries, the next
+// request reads the database again. Failing to do so only means stale data until UserExpTime
+func (app *application) invalidateUserCache(ctx context.Context, userID int64) {
+	if !app.config.redisCfg.enabled {
+		return
+	}
+
+	if err := app.cacheStorage.Users.Delete(ctx, userID); err != nil {
+		app.logger.Errorw("error invalidating cached user", "user", userID, "error", err)
+	}
+}
+
 // ex 65 RateLimiterMiddleware
 func (app *application) RateLimiterMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"social/internal/store"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRequirePermission(t *testing.T) {
+	app := newTestApplication(t, config{})
+
+	mux := chi.NewRouter()
+	mux.Use(app.AuthTokenMiddleware)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	mux.With(app.RequirePermission(store.PermPostsCreate)).Get("/create", ok)
+	mux.With(app.RequirePermission(store.PermRolesManage)).Get("/roles", ok)
+
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should allow a granted permission", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/create", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("should forbid a permission the role doesn't have", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/roles", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusForbidden, rr.Code)
+	})
+}
+
+func TestMFAEnrollmentRequired(t *testing.T) {
+	app := newTestApplication(t, config{})
+	app.store.Roles.(*store.MockRoleStore).Permissions = []string{store.PermMFARequired}
+
+	mux := chi.NewRouter()
+	mux.Use(app.AuthTokenMiddleware)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	mux.Get("/v1/posts", ok)
+	mux.Post("/v1/users/me/mfa/totp", ok)
+
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should forbid everything else until the user enrolled", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/v1/posts", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("should allow enrolling", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "/v1/users/me/mfa/totp", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("should force roles listed in the config", func(t *testing.T) {
+		app.store.Roles.(*store.MockRoleStore).Permissions = nil
+		app.config.auth.mfa.requiredRoles = []string{"user"}
+
+		req, err := http.NewRequest(http.MethodGet, "/v1/posts", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("should let other roles through", func(t *testing.T) {
+		app.config.auth.mfa.requiredRoles = []string{"admin"}
+
+		req, err := http.NewRequest(http.MethodGet, "/v1/posts", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusOK, rr.Code)
+	})
+}
+
+func TestAuthTokenMiddleware(t *testing.T) {
+	app := newTestApplication(t, config{})
+
+	mux := chi.NewRouter()
+	mux.Use(app.AuthTokenMiddleware)
+	mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		if getPrincipalFromContext(r) == nil {
+			t.Error("expected a principal in the context")
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should accept the session cookie", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: testToken})
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("should reject wrong basic credentials", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("gopher@example.com", "wrong password")
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("should reject requests without credentials", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusUnauthorized, rr.Code)
+	})
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"social/internal/auth"
+	"social/internal/env"
+	"social/internal/mailer"
+	"social/internal/store"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	oidcStateTokenType = "oidc_state"
+	oidcStateCookie    = "oidc_state"
+	oidcCookiePath     = "/v1/authentication/oidc"
+)
+
+var (
+	errUnknownProvider    = errors.New("unknown identity provider")
+	errInvalidOIDCState   = errors.New("invalid or expired login state")
+	errOIDCEmailRequired  = errors.New("the identity provider did not share an email address")
+	errOIDCEmailNotProven = errors.New("an account with that email already exists, log in with your password to link it")
+)
+
+// loadOIDCConfigs reads OIDC_PROVIDERS (e.g. "google,gitlab") and for every name the
+// OIDC_<NAME>_ISSUER_URL, OIDC_<NAME>_CLIENT_ID and OIDC_<NAME>_CLIENT_SECRET variables
+func loadOIDCConfigs() []auth.OIDCConfig {
+	redirectBase := env.GetString("OIDC_REDIRECT_BASE_URL", "http://localhost:8080"+oidcCookiePath)
+
+	var configs []auth.OIDCConfig
+	for _, name := range strings.Split(env.GetString("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		configs = append(configs, auth.OIDCConfig{
+			Name:         name,
+			IssuerURL:    env.GetString(prefix+"ISSUER_URL", ""),
+			ClientID:     env.GetString(prefix+"CLIENT_ID", ""),
+			ClientSecret: env.GetString(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  fmt.Sprintf("%s/%s/callback", strings.TrimSuffix(redirectBase, "/"), name),
+		})
+	}
+
+	return configs
+}
+
+// OIDCLogin godoc
+//
+//	@Summary		Starts a social login
+//	@Description	Redirects to the identity provider, the provider sends the user back to the callback
+//	@Tags			authentication
+//	@Param			provider	path		string	true	"Provider name"
+//	@Success		302			{string}	string	"Redirect to the provider"
+//	@Failure		400			{object}	error
+//	@Failure		500			{object}	error
+//	@Router			/authentication/oidc/{provider}/login [get]
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFoundError(w, r, errUnknownProvider)
+		return
+	}
+
+	state, err := auth.NewNonce()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	nonce, err := auth.NewNonce()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	verifier, challenge, err := auth.NewPKCE()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	//everything the callback needs to check goes into a signed cookie, no server side storage for half done logins.
+	//the state doubles as sid, ValidateToken wants one on every token
+	exp := app.config.auth.oidc.stateExp
+	claims := jwt.MapClaims{
+		"typ":   oidcStateTokenType,
+		"sid":   state,
+		"prv":   provider.Name(),
+		"nonce": nonce,
+		"cv":    verifier,
+		"exp":   time.Now().Add(exp).Unix(),
+		"iat":   time.Now().Unix(),
+		"nbf":   time.Now().Unix(),
+		"iss":   app.config.auth.token.iss,
+		"aud":   app.config.auth.token.iss,
+	}
+
+	stateToken, err := app.authenticator.GenerateToken(claims)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, challenge)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    stateToken,
+		Path:     oidcCookiePath,
+		MaxAge:   int(exp.Seconds()),
+		HttpOnly: true,
+		Secure:   app.config.env == "production",
+		//Lax still sends the cookie on the top level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback godoc
+//
+//	@Summary		Completes a social login
+//	@Description	The identity provider redirects here. Verified emails are linked to or create an active account,
+//	@Description	unverified ones get an account which has to be activated through the emailed link
+//	@Tags			authentication
+//	@Produce		json
+//	@Param			provider	path		string					true	"Provider name"
+//	@Param			code		query		string					true	"Authorization code"
+//	@Param			state		query		string					true	"State from the login redirect"
+//	@Success		201			{object}	TokenResponse			"Tokens"
+//	@Success		200			{object}	MFAChallengeResponse	"Second factor required"
+//	@Success		202			{string}	string					"Account created, activation email sent"
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		409			{object}	error
+//	@Failure		500			{object}	error
+//	@Router			/authentication/oidc/{provider}/callback [get]
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFoundError(w, r, errUnknownProvider)
+		return
+	}
+
+	//the state cookie is single use, whatever happens next
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: oidcCookiePath, MaxAge: -1, HttpOnly: true})
+
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		app.unauthorizedErrorResponse(w, r, fmt.Errorf("provider %s returned %s", provider.Name(), errCode))
+		return
+	}
+
+	claims, err := app.oidcState(r, provider.Name())
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		app.badRequestError(w, r, errors.New("code is required"))
+		return
+	}
+
+	ctx := r.Context()
+
+	nonce, _ := claims["nonce"].(string)
+	verifier, _ := claims["cv"].(string)
+	identity, err := provider.Exchange(ctx, code, verifier, nonce)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	user, err := app.store.Users.GetByIdentity(ctx, provider.Name(), identity.Subject)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.registerOIDCIdentity(w, r, provider.Name(), identity)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if !user.IsActive {
+		app.forbiddenResponse(w, r)
+		return
+	}
+	if user.IsLocked() {
+		app.unauthorizedErrorResponse(w, r, fmt.Errorf("account %d is locked", user.ID))
+		return
+	}
+
+	app.completeLogin(w, r, user, "oidc:"+provider.Name())
+}
+
+// oidcState checks the state cookie against the state query parameter and returns its claims
+func (app *application) oidcState(r *http.Request, provider string) (jwt.MapClaims, error) {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		return nil, errInvalidOIDCState
+	}
+
+	jwtToken, err := app.authenticator.ValidateToken(cookie.Value)
+	if err != nil {
+		return nil, errInvalidOIDCState
+	}
+
+	claims, _ := jwtToken.Claims.(jwt.MapClaims)
+	if typ, _ := claims["typ"].(string); typ != oidcStateTokenType {
+		return nil, errInvalidOIDCState
+	}
+	if prv, _ := claims["prv"].(string); prv != provider {
+		return nil, errInvalidOIDCState
+	}
+
+	state, _ := claims["sid"].(string)
+	if subtle.ConstantTimeCompare([]byte(state), []byte(r.URL.Query().Get("state"))) != 1 {
+		return nil, errInvalidOIDCState
+	}
+
+	return claims, nil
+}
+
+// registerOIDCIdentity handles the first login with an identity: a verified email links to the existing
+// account with that email or creates an active one, an unverified email only ever creates a new account
+func (app *application) registerOIDCIdentity(w http.ResponseWriter, r *http.Request, provider string, identity *auth.OIDCIdentity) {
+	if identity.Email == "" {
+		app.badRequestError(w, r, errOIDCEmailRequired)
+		return
+	}
+
+	ctx := r.Context()
+
+	link := &store.Identity{
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+
+	if identity.EmailVerified {
+		user, err := app.store.Users.LinkIdentity(ctx, link)
+		switch err {
+		case nil:
+			app.logger.Infow("identity linked", "user", user.ID, "provider", provider)
+			app.invalidateUserCache(ctx, user.ID)
+
+			if user.IsLocked() {
+				app.unauthorizedErrorResponse(w, r, fmt.Errorf("account %d is locked", user.ID))
+				return
+			}
+
+			app.completeLogin(w, r, user, "oidc:"+provider)
+			return
+		case store.ErrNotFound:
+			//no account with that email yet, created below
+		case store.ErrConflict:
+			//a parallel callback for the same identity won the race
+			app.conflictResponse(w, r, err)
+			return
+		default:
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	user := &store.User{
+		Username: oidcUsername(identity),
+		Email:    identity.Email,
+	}
+
+	//nobody knows this password, logging in with a password needs a reset first
+	randomPassword, err := auth.NewOpaqueToken()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	user.Password.SetPlaintext(randomPassword)
+
+	var plainToken, hashToken string
+	if !identity.EmailVerified {
+		plainToken = uuid.New().String()
+		hash := sha256.Sum256([]byte(plainToken))
+		hashToken = hex.EncodeToString(hash[:])
+	}
+
+	err = app.store.Users.CreateWithIdentity(ctx, user, link, hashToken, app.config.mail.exp)
+	if err != nil {
+		switch err {
+		case store.ErrDuplicateEmail:
+			//only a verified email may take over an existing account
+			app.conflictResponse(w, r, errOIDCEmailNotProven)
+		case store.ErrDuplicateUsername, store.ErrConflict:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.logger.Infow("user registered with identity", "user", user.ID, "provider", provider, "verified", identity.EmailVerified)
+
+	if identity.EmailVerified {
+		app.completeLogin(w, r, user, "oidc:"+provider)
+		return
+	}
+
+	activationURL := fmt.Sprintf("%s/confirm/%s", app.config.frontendURL, plainToken)
+
+	isProdEnv := app.config.env == "production"
+	vars := struct {
+		Username      string
+		ActivationURL string
+	}{
+		Username:      user.Username,
+		ActivationURL: activationURL,
+	}
+
+	app.background(func() {
+		status, err := app.mailer.Send(mailer.UserWelcomeTemplate, user.Username, user.Email, vars, !isProdEnv)
+		if err != nil {
+			app.logger.Errorw("error sending welcome email", "user", user.ID, "error", err)
+			return
+		}
+
+		app.logger.Infow("Email sent", "status code", status)
+	})
+
+	if err := app.jsonResponse(w, http.StatusAccepted, "account created, check your email to activate it"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+var usernameDisallowed = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// oidcUsername derives a username from the provider's preferred username or the email, with a random suffix
+// because usernames are unique and we don't want a second round trip to pick one
+func oidcUsername(identity *auth.OIDCIdentity) string {
+	base := identity.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	base = usernameDisallowed.ReplaceAllString(strings.ToLower(base), "")
+	if len(base) > 80 {
+		base = base[:80]
+	}
+	if base == "" {
+		base = "gopher"
+	}
+
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+
+	return base + "_" + hex.EncodeToString(suffix)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"social/internal/mailer"
+	"social/internal/store"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// ForgotPassword godoc
+//
+//	@Summary		Requests a password reset
+//	@Description	Emails a password reset link, the response is the same whether the email is registered or not
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ForgotPasswordPayload	true	"Account email"
+//	@Success		202		{string}	string					"Reset link sent if the account exists"
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/password/forgot [post]
+func (app *application) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ForgotPasswordPayload
+	err := readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = Validate.Struct(payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	//whatever happens below, the client always gets this answer
+	msg := "if an account exists for that email, a password reset link has been sent"
+
+	ctx := r.Context()
+
+	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			if err := app.jsonResponse(w, http.StatusAccepted, msg); err != nil {
+				app.internalServerError(w, r, err)
+			}
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	//the token and the email are done in the background, an unknown email answers right away and waiting
+	//for the insert here would tell the two apart
+	app.background(func() {
+		plainToken := uuid.New().String()
+		err := app.store.Users.CreateToken(context.Background(), user.ID, store.ScopePasswordReset, plainToken, app.config.mail.resetExp)
+		if err != nil {
+			app.logger.Errorw("error creating password reset token", "user", user.ID, "error", err)
+			return
+		}
+
+		app.audit(r, store.AuditEvent{Action: store.AuditPasswordResetRequest, TargetType: "user", TargetID: user.ID})
+
+		resetURL := fmt.Sprintf("%s/password/reset/%s", app.config.frontendURL, plainToken)
+
+		isProdEnv := app.config.env == "production"
+		vars := struct {
+			Username string
+			ResetURL string
+			Expiry   string
+		}{
+			Username: user.Username,
+			ResetURL: resetURL,
+			Expiry:   app.config.mail.resetExp.String(),
+		}
+
+		status, err := app.mailer.Send(mailer.PasswordResetTemplate, user.Username, user.Email, vars, !isProdEnv)
+		if err != nil {
+			app.logger.Errorw("error sending password reset email", "user", user.ID, "error", err)
+			return
+		}
+
+		app.logger.Infow("Email sent", "status code", status)
+	})
+
+	if err := app.jsonResponse(w, http.StatusAccepted, msg); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type ResetPasswordPayload struct {
+	Password string `json:"password" validate:"required,max=1024"`
+}
+
+// ResetPassword godoc
+//
+//	@Summary		Resets a password
+//	@Description	Sets a new password using the token from the reset email, all sessions and API keys of the user are revoked
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			token	path		string					true	"Reset token"
+//	@Param			payload	body		ResetPasswordPayload	true	"New password"
+//	@Success		204		{string}	string					"Password reset"
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/password/reset/{token} [put]
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	var payload ResetPasswordPayload
+	err := readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = Validate.Struct(payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := app.passwordPolicy.Check(payload.Password); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	user := &store.User{}
+	user.Password.SetPlaintext(payload.Password)
+
+	err = app.store.Users.ResetPassword(r.Context(), token, user)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	//the refresh token families went with the reset, access tokens go like after logging out everywhere
+	err = app.denylist().RevokeIssuedBefore(r.Context(), user.ID, time.Now(), app.config.auth.token.exp)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, store.AuditEvent{ActorID: user.ID, Action: store.AuditPasswordReset, TargetType: "user", TargetID: user.ID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"social/internal/mailer"
+	"social/internal/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasswordReset(t *testing.T) {
+	app := newTestApplication(t, config{})
+	app.config.mail.resetExp = time.Hour
+	mux := app.mount()
+
+	users := app.store.Users.(*store.MockUserStore)
+	users.Email = "gopher@example.com"
+	mails := app.mailer.(*mailer.MockClient)
+
+	forgot := func(email string) int {
+		body := strings.NewReader(`{"email": "` + email + `"}`)
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/password/forgot", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+		//the token and the email are created in the background
+		app.wg.Wait()
+		return rr.Code
+	}
+
+	reset := func(token string) int {
+		body := strings.NewReader(`{"password": "correct-horse-battery"}`)
+		req, err := http.NewRequest(http.MethodPut, "/v1/authentication/password/reset/"+token, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return excuteRequest(req, mux).Code
+	}
+
+	var token string
+
+	t.Run("should accept an unknown email without sending anything", func(t *testing.T) {
+		checkResponseCode(t, http.StatusAccepted, forgot("nobody@example.com"))
+
+		if users.Token(store.ScopePasswordReset) != "" {
+			t.Error("expected no reset token for an unknown email")
+		}
+		if n := mails.Count(mailer.PasswordResetTemplate); n != 0 {
+			t.Errorf("expected no reset email, got %d", n)
+		}
+	})
+
+	t.Run("should send a reset link to a known email", func(t *testing.T) {
+		checkResponseCode(t, http.StatusAccepted, forgot("gopher@example.com"))
+
+		token = users.Token(store.ScopePasswordReset)
+		if token == "" {
+			t.Fatal("expected a reset token")
+		}
+		if n := mails.Count(mailer.PasswordResetTemplate); n != 1 {
+			t.Errorf("expected 1 reset email, got %d", n)
+		}
+	})
+
+	t.Run("should reject a bad token", func(t *testing.T) {
+		checkResponseCode(t, http.StatusBadRequest, reset("not-a-token"))
+	})
+
+	t.Run("should reject an expired token", func(t *testing.T) {
+		err := users.CreateToken(context.Background(), 0, store.ScopePasswordReset, "expired-token", -time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkResponseCode(t, http.StatusBadRequest, reset("expired-token"))
+	})
+
+	t.Run("should reset the password once", func(t *testing.T) {
+		checkResponseCode(t, http.StatusNoContent, reset(token))
+		checkResponseCode(t, http.StatusBadRequest, reset(token))
+
+		//the access tokens are logged out like the refresh tokens
+		before, err := app.denylist().IssuedBefore(context.Background(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if before.IsZero() {
+			t.Error("expected the access tokens issued before the reset to be revoked")
+		}
+	})
+}
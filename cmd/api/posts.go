@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"social/internal/store"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -24,12 +26,18 @@ type CreatePostPayload struct {
 	Title   string   `json:"title" validate:"required,max=100"`
 	Content string   `json:"content" validate:"required,max=1000"`
 	Tags    []string `json:"tags"`
+	//drafts are only visible to their author until they are published
+	Draft bool `json:"draft"`
+	//schedules the post as a draft the publisher job publishes then
+	PublishAt *time.Time `json:"publish_at"`
+	//uploaded with POST /media and not attached to another post yet
+	MediaIDs []int64 `json:"media_ids" validate:"max=10,unique"`
 }
 
 // CreatePost godoc
 //
 //	@Summary		Creates a post
-//	@Description	Creates a post
+//	@Description	Creates a post, it is published right away unless it is a draft or scheduled with publish_at
 //	@Tags			posts
 //	@Accept			json
 //	@Produce		json
@@ -63,23 +71,39 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if payload.PublishAt != nil && !payload.PublishAt.After(time.Now()) {
+		app.badRequestError(w, r, errPublishAtNotInFuture)
+		return
+	}
+
 	user := getUserFromContext(r)
 
 	post := &store.Post{
-		Title:   payload.Title,
-		Content: payload.Content,
-		Tags:    payload.Tags,
-		UserID:  user.ID,
+		Title:     payload.Title,
+		Content:   payload.Content,
+		Tags:      payload.Tags,
+		UserID:    user.ID,
+		Draft:     payload.Draft || payload.PublishAt != nil,
+		PublishAt: payload.PublishAt,
+	}
+	for _, id := range payload.MediaIDs {
+		post.Media = append(post.Media, store.Media{ID: id})
 	}
 
 	ctx := r.Context()
 
 	err = app.store.Posts.Create(ctx, post)
 	if err != nil {
-		app.internalServerError(w, r, err)
+		switch err {
+		case store.ErrMediaUnavailable:
+			app.badRequestError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
 		return
 	}
 
+	w.Header().Set("ETag", postETag(post))
 	err = app.jsonResponse(w, http.StatusCreated, post)
 	if err != nil {
 		app.internalServerError(w, r, err)
@@ -88,6 +112,12 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 
 }
 
+// postResponse is a post with its reactions, the first page of its comments and the cursor to the next one
+type postResponse struct {
+	store.PostWithMetadata
+	CommentsNextCursor string `json:"comments_next_cursor,omitempty"`
+}
+
 // GetPost godoc
 //
 //	@Summary		Fetches a post
@@ -95,9 +125,11 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 //	@Tags			posts
 //	@Accept			json
 //	@Produce		json
-//	@Param			id	path		int	true	"Post ID"
-//	@Success		200	{object}	store.Post
-//	@Failure		404	{object}	error
+//	@Param			id				path		int		true	"Post ID"
+//	@Param			If-None-Match	header		string	false	"ETag of the copy the client has"
+//	@Success		200				{object}	postResponse
+//	@Success		304				{string}	string	"Not modified"
+//	@Failure		404				{object}	error
 //	@Failure		500	{object}	error
 //	@Security		ApiKeyAuth
 //	@Router			/posts/{id} [get]
@@ -124,15 +156,49 @@ func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
 	// }
 
 	//Exercise 27 everytime we fetch post lets fetch its comments as well
-	comments, err := app.store.Comments.GetByPostID(r.Context(), post.ID)
+	//only the first page though, the rest comes from GET /posts/{id}/comments with next_cursor
+	page, err := app.store.Comments.GetByPostID(r.Context(), post.ID, store.CursorQuery{Limit: defaultCommentPageSize})
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	count, err := app.store.Comments.CountByPostID(r.Context(), post.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	reactions, err := app.store.Reactions.GetSummary(r.Context(), post.ID, getUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	post.Comments = page.Comments
+
+	response := postResponse{
+		PostWithMetadata:   store.PostWithMetadata{Post: *post, CommentCount: count, ReactionSummary: *reactions},
+		CommentsNextCursor: page.NextCursor,
+	}
+
+	//the tag covers everything in the body, which depends on who is asking
+	body, err := json.Marshal(response)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
-	post.Comments = comments
+	etag := postResponseETag(post, body)
+	w.Header().Set("ETag", etag)
+	//every header newAuthChain can read the principal from
+	w.Header().Set("Vary", "Authorization, X-API-Key, Cookie")
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
-	err = app.jsonResponse(w, http.StatusOK, post)
+	err = app.jsonResponse(w, http.StatusOK, response)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
@@ -147,25 +213,29 @@ func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
 //	@Tags			posts
 //	@Accept			json
 //	@Produce		json
-//	@Param			id	path		int	true	"Post ID"
-//	@Success		204	{object} string
-//	@Failure		404	{object}	error
-//	@Failure		500	{object}	error
+//	@Param			id			path		int		true	"Post ID"
+//	@Param			If-Match	header		string	false	"ETag the client last saw"
+//	@Success		204			{object}	string
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error
+//	@Failure		412			{object}	error
+//	@Failure		500			{object}	error
 //	@Security		ApiKeyAuth
 //	@Router			/posts/{id} [delete]
 //
 // excerise 28 deleting and updating post
 func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request) {
-	idParam := chi.URLParam(r, "postID")
-	id, err := strconv.ParseInt(idParam, 10, 64)
-	if err != nil {
-		app.internalServerError(w, r, err)
+	post := getPostFromCtx(r)
+	if !app.checkPostIfMatch(w, r, post) {
 		return
 	}
+
 	ctx := r.Context()
 
-	if err := app.store.Posts.Delete(ctx, id); err != nil {
+	if err := app.store.Posts.Delete(ctx, post.ID, post.Version); err != nil {
 		switch {
+		case errors.Is(err, store.ErrEditConflict):
+			app.postEditConflict(w, r, post.ID)
 		case errors.Is(err, store.ErrNotFound):
 			app.notFoundError(w, r, err)
 		default:
@@ -195,22 +265,29 @@ type UpdatePostPayload struct {
 //	@Tags			posts
 //	@Accept			json
 //	@Produce		json
-//	@Param			id		path		int					true	"Post ID"
-//	@Param			payload	body		UpdatePostPayload	true	"Post payload"
-//	@Success		200		{object}	store.Post
-//	@Failure		400		{object}	error
-//	@Failure		401		{object}	error
-//	@Failure		404		{object}	error
-//	@Failure		500		{object}	error
+//	@Param			id			path		int					true	"Post ID"
+//	@Param			If-Match	header		string				false	"ETag the client last saw"
+//	@Param			payload		body		UpdatePostPayload	true	"Post payload"
+//	@Success		200			{object}	store.Post
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error
+//	@Failure		412			{object}	error
+//	@Failure		500			{object}	error
 //	@Security		ApiKeyAuth
 //	@Router			/posts/{id} [patch]
 func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request) {
 	post := getPostFromCtx(r)
+	if !app.checkPostIfMatch(w, r, post) {
+		return
+	}
 
 	var payload UpdatePostPayload
 	err := readJSON(w, r, &payload)
 	if err != nil {
 		app.badRequestError(w, r, err)
+		return
 	}
 
 	err = Validate.Struct(payload)
@@ -227,11 +304,21 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 		post.Title = *payload.Title
 	}
 
-	if err := app.store.Posts.Update(r.Context(), post); err != nil {
-		app.internalServerError(w, r, err)
+	//the previous version stays in the revisions, together with who changed it
+	if err := app.store.Posts.Update(r.Context(), post, getUserFromContext(r).ID); err != nil {
+		switch err {
+		case store.ErrEditConflict:
+			app.postEditConflict(w, r, post.ID)
+		case store.ErrNotFound:
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
 		return
 	}
 
+	w.Header().Set("ETag", postETag(post))
+
 	if err := app.jsonResponse(w, http.StatusOK, post); err != nil {
 		app.internalServerError(w, r, err)
 		return
@@ -261,6 +348,12 @@ func (app *application) postsContextMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		//nobody but the author knows about a draft
+		if post.Draft && post.UserID != getUserFromContext(r).ID {
+			app.notFoundError(w, r, store.ErrNotFound)
+			return
+		}
+
 		//Excersie 28 we need to use previous context ctx := r.Context() and create new context and
 		//insert our post in it. We never mutate context but always create a new one from scratch
 		//should not use basic type untyped string as key in context.WithValue not a best pratice to avoid collusions
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"social/internal/store"
+	"strings"
+	"testing"
+)
+
+func TestPostETags(t *testing.T) {
+	app := newTestApplication(t, config{})
+	mux := app.mount()
+
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the mock store keeps every post at version 0
+	request := func(method, path, body string, header ...string) *http.Request {
+		t.Helper()
+
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		return req
+	}
+
+	var etag string
+
+	t.Run("should send the etag of a post", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodGet, "/v1/posts/1", ""), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+
+		//comments and reactions are part of the body, so the tag is more than the version
+		etag = rr.Header().Get("ETag")
+		if !strings.HasPrefix(etag, `"0-`) {
+			t.Errorf(`expected an ETag of version 0, got %s`, etag)
+		}
+		if vary := rr.Header().Get("Vary"); !strings.Contains(vary, "Authorization") {
+			t.Errorf("expected to vary by Authorization, got %q", vary)
+		}
+	})
+
+	t.Run("should answer not modified to a current copy", func(t *testing.T) {
+		for _, tag := range []string{etag, "W/" + etag, `"3", ` + etag, "*"} {
+			rr := excuteRequest(request(http.MethodGet, "/v1/posts/1", "", "If-None-Match", tag), mux)
+			checkResponseCode(t, http.StatusNotModified, rr.Code)
+		}
+
+		for _, tag := range []string{`"3"`, `"0"`} {
+			rr := excuteRequest(request(http.MethodGet, "/v1/posts/1", "", "If-None-Match", tag), mux)
+			checkResponseCode(t, http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("should change the etag when only the reactions change", func(t *testing.T) {
+		app.store.Reactions = &store.MockReactionStore{Reactions: map[string]int{"like": 1}}
+		defer func() { app.store.Reactions = &store.MockReactionStore{} }()
+
+		rr := excuteRequest(request(http.MethodGet, "/v1/posts/1", "", "If-None-Match", etag), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+
+		if got := rr.Header().Get("ETag"); got == etag {
+			t.Errorf("expected a new ETag, got %s again", got)
+		}
+	})
+
+	t.Run("should not change a post based on an outdated copy", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodPatch, "/v1/posts/1", `{"title": "new title"}`, "If-Match", `"3"`), mux)
+		checkResponseCode(t, http.StatusPreconditionFailed, rr.Code)
+
+		rr = excuteRequest(request(http.MethodPatch, "/v1/posts/1", `{"title": "new title"}`, "If-Match", `W/"0"`), mux)
+		checkResponseCode(t, http.StatusPreconditionFailed, rr.Code)
+
+		rr = excuteRequest(request(http.MethodDelete, "/v1/posts/1", "", "If-Match", `"3"`), mux)
+		checkResponseCode(t, http.StatusPreconditionFailed, rr.Code)
+	})
+
+	t.Run("should change a post based on the current copy", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodPatch, "/v1/posts/1", `{"title": "new title"}`, "If-Match", `"0"`), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+
+		if etag := rr.Header().Get("ETag"); etag != `"1"` {
+			t.Errorf(`expected ETag "1", got %s`, etag)
+		}
+
+		//the tag of GET /posts/{id} works as well
+		rr = excuteRequest(request(http.MethodPatch, "/v1/posts/1", `{"title": "new title"}`, "If-Match", etag), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+
+		rr = excuteRequest(request(http.MethodDelete, "/v1/posts/1", "", "If-Match", `"0"`), mux)
+		checkResponseCode(t, http.StatusNoContent, rr.Code)
+	})
+
+	t.Run("should send the current post to the loser of a concurrent write", func(t *testing.T) {
+		app.store.Posts = &store.MockPostStore{Conflict: true}
+		defer func() { app.store.Posts = &store.MockPostStore{} }()
+
+		rr := excuteRequest(request(http.MethodPatch, "/v1/posts/1", `{"title": "new title"}`), mux)
+		checkResponseCode(t, http.StatusConflict, rr.Code)
+
+		if !strings.Contains(rr.Body.String(), `"data"`) || rr.Header().Get("ETag") == "" {
+			t.Errorf("expected the current post and its etag, got %s", rr.Body.String())
+		}
+
+		rr = excuteRequest(request(http.MethodDelete, "/v1/posts/1", ""), mux)
+		checkResponseCode(t, http.StatusConflict, rr.Code)
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"social/internal/auth"
+	"social/internal/store"
+	"strings"
+	"time"
+)
+
+// the access token cookie of the session_cookie method
+const sessionCookieName = "gophersocial_session"
+
+// newAuthChain builds the extractors of AuthTokenMiddleware in the configured order
+func (app *application) newAuthChain(methods []string) (auth.Chain, error) {
+	chain := make(auth.Chain, 0, len(methods))
+
+	for _, method := range methods {
+		switch auth.Method(strings.TrimSpace(method)) {
+		case auth.MethodBearer:
+			chain = append(chain, &auth.BearerExtractor{Authenticator: app.authenticator})
+		case auth.MethodSessionCookie:
+			chain = append(chain, &auth.CookieExtractor{Authenticator: app.authenticator, Name: sessionCookieName})
+		case auth.MethodAPIKey:
+			chain = append(chain, &auth.APIKeyExtractor{Lookup: app.lookupAPIKey})
+		case auth.MethodBasic:
+			chain = append(chain, &auth.BasicExtractor{Lookup: app.lookupBasicCredentials})
+		default:
+			return nil, fmt.Errorf("unknown authentication method %q", method)
+		}
+	}
+
+	return chain, nil
+}
+
+func (app *application) lookupAPIKey(r *http.Request, plainKey string) (int64, []string, error) {
+	key, err := app.store.APIKeys.GetByKey(r.Context(), plainKey)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return 0, nil, auth.ErrInvalidCredentials
+		default:
+			return 0, nil, err
+		}
+	}
+
+	return key.UserID, key.Scopes, nil
+}
+
+// lookupBasicCredentials checks a password on every request, which is slow by design, so it is meant for
+// scripts and not turned on by default. Accounts with 2FA can't use it, there is no way to send a code
+func (app *application) lookupBasicCredentials(r *http.Request, email, password string) (int64, error) {
+	ctx := r.Context()
+
+	user, err := app.store.Users.GetByEmail(ctx, email)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			_ = app.store.Users.ComparePassword(nil, password)
+			return 0, auth.ErrInvalidCredentials
+		default:
+			return 0, err
+		}
+	}
+
+	err = app.store.Users.ComparePassword(user, password)
+	if user.IsLocked() {
+		return 0, fmt.Errorf("%w: account %d is locked", auth.ErrInvalidCredentials, user.ID)
+	}
+	if err != nil {
+		switch err {
+		case store.ErrPasswordMismatch:
+			app.recordFailedLogin(r, user, "wrong_password")
+			return 0, auth.ErrInvalidCredentials
+		default:
+			return 0, err
+		}
+	}
+
+	if user.MFAEnabled {
+		return 0, fmt.Errorf("%w: basic auth is not available with 2FA", auth.ErrInvalidCredentials)
+	}
+
+	return user.ID, nil
+}
+
+// setSessionCookie hands the access token to browsers as well when the session_cookie method is on
+func (app *application) setSessionCookie(w http.ResponseWriter, tokens *TokenResponse) {
+	if !slices.Contains(app.config.auth.methods, string(auth.MethodSessionCookie)) {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    tokens.AccessToken,
+		Path:     "/",
+		MaxAge:   int(tokens.ExpiresIn),
+		HttpOnly: true,
+		Secure:   app.config.env == "production",
+		//Strict keeps other sites from making requests with it, the cookie is all a request needs
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Path: "/", MaxAge: -1, Expires: time.Unix(0, 0), HttpOnly: true})
+}
+
+// getPrincipalFromContext returns who AuthTokenMiddleware authenticated, nil on public routes
+func getPrincipalFromContext(r *http.Request) *auth.Principal {
+	principal, _ := r.Context().Value(principalCtx).(*auth.Principal)
+	return principal
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"social/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var errUnknownReaction = errors.New("unknown reaction kind")
+
+// reactionKind returns the kind in the URL if it is one we accept
+func (app *application) reactionKind(r *http.Request) (string, error) {
+	kind := chi.URLParam(r, "kind")
+	if !slices.Contains(app.config.reactions.kinds, kind) {
+		return "", errUnknownReaction
+	}
+
+	return kind, nil
+}
+
+// React godoc
+//
+//	@Summary		Reacts to a post
+//	@Description	Reacts to a post with one of the configured kinds, reacting twice with the same kind counts once
+//	@Tags			reactions
+//	@Param			postID	path		int		true	"Post ID"
+//	@Param			kind	path		string	true	"Reaction kind, e.g. like"
+//	@Success		204		{string}	string	"Reacted"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/reactions/{kind} [put]
+func (app *application) reactHandler(w http.ResponseWriter, r *http.Request) {
+	kind, err := app.reactionKind(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	post := getPostFromCtx(r)
+
+	if err := app.store.Reactions.React(r.Context(), post.ID, user.ID, kind); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Unreact godoc
+//
+//	@Summary		Takes back a reaction
+//	@Description	Takes back a reaction to a post, it is fine if there was none
+//	@Tags			reactions
+//	@Param			postID	path		int		true	"Post ID"
+//	@Param			kind	path		string	true	"Reaction kind, e.g. like"
+//	@Success		204		{string}	string	"Reaction removed"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/reactions/{kind} [delete]
+func (app *application) unreactHandler(w http.ResponseWriter, r *http.Request) {
+	//kinds taken out of the config can still be removed
+	kind := chi.URLParam(r, "kind")
+
+	user := getUserFromContext(r)
+	post := getPostFromCtx(r)
+
+	if err := app.store.Reactions.Unreact(r.Context(), post.ID, user.ID, kind); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListReactions godoc
+//
+//	@Summary		Lists who reacted to a post
+//	@Description	Lists who reacted to a post newest first, pass next_cursor back as cursor for the next page
+//	@Tags			reactions
+//	@Produce		json
+//	@Param			postID	path		int		true	"Post ID"
+//	@Param			kind	query		string	false	"Only reactions of this kind"
+//	@Param			limit	query		int		false	"Limit"
+//	@Param			cursor	query		string	false	"Cursor from the previous page"
+//	@Success		200		{object}	store.ReactionPage
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/reactions [get]
+func (app *application) listReactionsHandler(w http.ResponseWriter, r *http.Request) {
+	post := getPostFromCtx(r)
+
+	q := store.ReactionQuery{
+		CursorQuery: store.CursorQuery{Limit: 20},
+	}
+
+	q, err := q.Parse(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	page, err := app.store.Reactions.GetByPostID(r.Context(), post.ID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, page); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
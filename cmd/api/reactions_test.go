@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestReactions(t *testing.T) {
+	app := newTestApplication(t, config{})
+	app.config.reactions.kinds = []string{"like", "laugh"}
+	mux := app.mount()
+
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, path string) *http.Request {
+		t.Helper()
+
+		req, err := http.NewRequest(method, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		return req
+	}
+
+	t.Run("should react with a configured kind", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodPut, "/v1/posts/1/reactions/like"), mux)
+		checkResponseCode(t, http.StatusNoContent, rr.Code)
+	})
+
+	t.Run("should not react with an unknown kind", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodPut, "/v1/posts/1/reactions/shrug"), mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("should take back a reaction", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodDelete, "/v1/posts/1/reactions/like"), mux)
+		checkResponseCode(t, http.StatusNoContent, rr.Code)
+	})
+
+	t.Run("should list who reacted", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodGet, "/v1/posts/1/reactions?kind=like&limit=5"), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("should embed reactions in the post", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodGet, "/v1/posts/1"), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+
+		var body struct {
+			Data map[string]any `json:"data"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range []string{"reactions", "my_reactions"} {
+			if _, ok := body.Data[field]; !ok {
+				t.Errorf("expected %s in %v", field, body.Data)
+			}
+		}
+	})
+
+	t.Run("should serve the feed of the authenticated user", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodGet, "/v1/users/feed"), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+	})
+}
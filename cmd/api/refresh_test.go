@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefreshToken(t *testing.T) {
+	app := newTestApplication(t, config{})
+	app.config.auth.token.refreshExp = time.Hour
+	mux := app.mount()
+
+	ctx := context.Background()
+
+	refresh := func(t *testing.T, token string) (int, TokenResponse) {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/refresh", strings.NewReader(`{"refresh_token": "`+token+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+
+		var res struct {
+			Data TokenResponse `json:"data"`
+		}
+		if rr.Code == http.StatusCreated {
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr.Code, res.Data
+	}
+
+	t.Run("should rotate a refresh token", func(t *testing.T) {
+		if err := app.store.RefreshTokens.Create(ctx, "rotate-1", 1, "family-rotate", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		code, tokens := refresh(t, "rotate-1")
+		checkResponseCode(t, http.StatusCreated, code)
+		if tokens.RefreshToken == "" || tokens.RefreshToken == "rotate-1" || tokens.AccessToken == "" {
+			t.Fatalf("expected a new token pair, got %+v", tokens)
+		}
+
+		//the new one works once as well
+		code, _ = refresh(t, tokens.RefreshToken)
+		checkResponseCode(t, http.StatusCreated, code)
+	})
+
+	t.Run("should revoke the family when an old token is replayed", func(t *testing.T) {
+		if err := app.store.RefreshTokens.Create(ctx, "replay-1", 1, "family-replay", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		code, tokens := refresh(t, "replay-1")
+		checkResponseCode(t, http.StatusCreated, code)
+
+		code, _ = refresh(t, "replay-1")
+		checkResponseCode(t, http.StatusUnauthorized, code)
+
+		//the access tokens of the family go with it
+		revoked, err := app.denylist().IsFamilyRevoked(ctx, "family-replay")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !revoked {
+			t.Error("expected the access tokens of the family to be revoked")
+		}
+
+		//whoever got the rotated token is logged out as well
+		code, _ = refresh(t, tokens.RefreshToken)
+		checkResponseCode(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("should reject an expired refresh token", func(t *testing.T) {
+		if err := app.store.RefreshTokens.Create(ctx, "expired-1", 1, "family-expired", -time.Minute); err != nil {
+			t.Fatal(err)
+		}
+
+		code, _ := refresh(t, "expired-1")
+		checkResponseCode(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("should reject an unknown refresh token", func(t *testing.T) {
+		code, _ := refresh(t, "unknown")
+		checkResponseCode(t, http.StatusUnauthorized, code)
+	})
+}
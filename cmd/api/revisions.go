@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"social/internal/store"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ListPostRevisions godoc
+//
+//	@Summary		Lists the revisions of a post
+//	@Description	Lists the revisions of a post newest first, with the fields each one changed. Only the author
+//	@Description	and users with posts.update.any can, old revisions may hold what a moderator took out
+//	@Tags			posts
+//	@Produce		json
+//	@Param			postID	path		int		true	"Post ID"
+//	@Param			limit	query		int		false	"Limit"
+//	@Param			cursor	query		string	false	"Cursor from the previous page"
+//	@Success		200		{object}	store.PostRevisionPage
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/revisions [get]
+func (app *application) listPostRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	post := getPostFromCtx(r)
+
+	q := store.CursorQuery{
+		Limit: 20,
+	}
+
+	q, err := q.Parse(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	page, err := app.store.PostRevisions.GetByPostID(r.Context(), post.ID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, page); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// RestorePostRevision godoc
+//
+//	@Summary		Restores a revision of a post
+//	@Description	Puts the title, content and tags of a revision back as a new revision
+//	@Tags			posts
+//	@Produce		json
+//	@Param			postID		path		int		true	"Post ID"
+//	@Param			version		path		int		true	"Version to restore"
+//	@Param			If-Match	header		string	false	"ETag the client last saw"
+//	@Success		200			{object}	store.Post
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		409			{object}	error
+//	@Failure		412			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/revisions/{version}/restore [post]
+func (app *application) restorePostRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	post := getPostFromCtx(r)
+	if !app.checkPostIfMatch(w, r, post) {
+		return
+	}
+
+	if err := app.store.Posts.Restore(r.Context(), post, version, user.ID); err != nil {
+		switch err {
+		case store.ErrEditConflict:
+			app.postEditConflict(w, r, post.ID)
+		case store.ErrNotFound:
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", postETag(post))
+	if err := app.jsonResponse(w, http.StatusOK, post); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"social/internal/store"
+	"testing"
+)
+
+func TestPostRevisions(t *testing.T) {
+	app := newTestApplication(t, config{})
+	mux := app.mount()
+
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, path string, body []byte) *http.Request {
+		t.Helper()
+
+		req, err := http.NewRequest(method, path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		return req
+	}
+
+	decodePost := func(t *testing.T, body *bytes.Buffer) store.Post {
+		t.Helper()
+
+		var res struct {
+			Data store.Post `json:"data"`
+		}
+		if err := json.NewDecoder(body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res.Data
+	}
+
+	t.Run("should flag updated posts as edited", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodPatch, "/v1/posts/1", []byte(`{"title": "new title"}`)), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+
+		if post := decodePost(t, rr.Body); !post.Edited || post.Version != 1 {
+			t.Errorf("expected an edited post at version 1, got %+v", post)
+		}
+	})
+
+	t.Run("should list revisions", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodGet, "/v1/posts/1/revisions?limit=5", nil), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("should restore a revision", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodPost, "/v1/posts/1/revisions/0/restore", nil), mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+
+		if post := decodePost(t, rr.Body); !post.Edited {
+			t.Errorf("expected the restored post to be edited, got %+v", post)
+		}
+	})
+
+	t.Run("should not restore a revision that doesn't exist", func(t *testing.T) {
+		rr := excuteRequest(request(http.MethodPost, "/v1/posts/1/revisions/5/restore", nil), mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+
+		rr = excuteRequest(request(http.MethodPost, "/v1/posts/1/revisions/latest/restore", nil), mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"social/internal/store"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// user agents are stored as the client sends them, up to this length
+const maxUserAgentLength = 512
+
+// sessionActivity collects when sessions were last seen so AuthTokenMiddleware doesn't write on every
+// request, the flush-session-activity job writes everything collected in one query
+type sessionActivity struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func (s *sessionActivity) touch(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.seen == nil {
+		s.seen = map[string]time.Time{}
+	}
+	s.seen[sessionID] = time.Now()
+}
+
+// drain returns what was collected since the last drain
+func (s *sessionActivity) drain() map[string]time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := s.seen
+	s.seen = nil
+	return seen
+}
+
+// restore puts back a batch which couldn't be written, newer touches win
+func (s *sessionActivity) restore(seen map[string]time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.seen == nil {
+		s.seen = map[string]time.Time{}
+	}
+	for id, t := range seen {
+		if _, ok := s.seen[id]; !ok {
+			s.seen[id] = t
+		}
+	}
+}
+
+// flushSessionActivity writes the collected last seen times, a failed batch is kept for the next run
+// unless the session was seen again in the meantime
+func (app *application) flushSessionActivity(ctx context.Context) error {
+	seen := app.sessionActivity.drain()
+
+	if err := app.store.Sessions.Touch(ctx, seen); err != nil {
+		app.sessionActivity.restore(seen)
+		return err
+	}
+
+	return nil
+}
+
+// purgeEndedSessions removes sessions nobody can use anymore, once they are as old as a refresh token
+func (app *application) purgeEndedSessions(ctx context.Context) error {
+	sessions, err := app.store.Sessions.PurgeEnded(ctx, time.Now().Add(-app.config.auth.token.refreshExp))
+	if err != nil {
+		return err
+	}
+
+	if sessions > 0 {
+		app.logger.Infow("purged ended sessions", "sessions", sessions)
+	}
+
+	return nil
+}
+
+// newSession records a login together with its first refresh token, its ID becomes the refresh token family
+func (app *application) newSession(r *http.Request, userID int64, refreshToken string) (*store.Session, error) {
+	//the column counts characters, cutting bytes could split a multibyte one and fail the insert
+	userAgent := r.UserAgent()
+	if utf8.RuneCountInString(userAgent) > maxUserAgentLength {
+		userAgent = string([]rune(userAgent)[:maxUserAgentLength])
+	}
+
+	session := &store.Session{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		UserAgent: userAgent,
+		IP:        clientIP(r),
+	}
+
+	err := app.store.Sessions.Create(r.Context(), session, refreshToken, app.config.auth.token.refreshExp)
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// ListSessions godoc
+//
+//	@Summary		Lists sessions
+//	@Description	Lists where the authenticated user is logged in, the session of this request has current set
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{object}	[]store.Session
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/sessions [get]
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	sessions, err := app.store.Sessions.GetActiveByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	sid := getPrincipalFromContext(r).SessionID
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == sid
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, sessions); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// RevokeSession godoc
+//
+//	@Summary		Revokes a session
+//	@Description	Logs one of the authenticated user's sessions out, its access and refresh tokens stop working
+//	@Tags			users
+//	@Param			sessionID	path		string	true	"Session ID"
+//	@Success		204			{string}	string	"Session revoked"
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/sessions/{sessionID} [delete]
+func (app *application) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	sessionID, err := uuid.Parse(chi.URLParam(r, "sessionID"))
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = app.store.Sessions.Revoke(r.Context(), sessionID.String(), user.ID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.revokeFamilyAccess(r.Context(), sessionID.String()); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, store.AuditEvent{
+		ActorID:    user.ID,
+		Action:     store.AuditSessionRevoked,
+		TargetType: "session",
+		Metadata:   map[string]any{"session_id": sessionID.String()},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"social/internal/store"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSessions(t *testing.T) {
+	app := newTestApplication(t, config{})
+	mux := app.mount()
+
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should mark the session of the request as current", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/v1/users/me/sessions", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+		checkResponseCode(t, http.StatusOK, rr.Code)
+
+		var body struct {
+			Data []store.Session `json:"data"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Data) != 1 || !body.Data[0].Current {
+			t.Errorf("expected the test session to be current, got %+v", body.Data)
+		}
+	})
+
+	t.Run("should batch last seen times instead of writing them", func(t *testing.T) {
+		seen := app.sessionActivity.drain()
+		if _, ok := seen["test-session"]; !ok {
+			t.Errorf("expected test-session to be touched, got %v", seen)
+		}
+	})
+
+	t.Run("should reject a malformed session id", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, "/v1/users/me/sessions/not-a-uuid", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("should revoke a session", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, "/v1/users/me/sessions/6f1c5a44-3f5e-4f7a-9a55-2b7e4f0c9d11", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+		checkResponseCode(t, http.StatusNoContent, rr.Code)
+	})
+	t.Run("should cut a long user agent on a character boundary", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "/v1/authentication/token", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("User-Agent", "a"+strings.Repeat("é", maxUserAgentLength))
+
+		session, err := app.newSession(req, 1, "refresh-token")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !utf8.ValidString(session.UserAgent) {
+			t.Errorf("expected valid UTF-8, got %q", session.UserAgent)
+		}
+		if n := utf8.RuneCountInString(session.UserAgent); n != maxUserAgentLength {
+			t.Errorf("expected %d characters, got %d", maxUserAgentLength, n)
+		}
+	})
+}
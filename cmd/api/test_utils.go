@@ -4,10 +4,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"social/internal/auth"
+	"social/internal/blob"
+	"social/internal/mailer"
 	"social/internal/ratelimiter"
 	"social/internal/store"
 	"social/internal/store/cache"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
@@ -30,13 +33,35 @@ func newTestApplication(t *testing.T, cfg config) *application {
 		cfg.rateLimiter.TimeFrame,
 	)
 
-	return &application{
-		logger:        logger,
-		store:         mockStore,
-		cacheStorage:  mockCacheStore,
-		authenticator: testAuth,
-		rateLimiter:   rateLimiter,
+	passwordPolicy, err := auth.NewPasswordPolicy(8, 128, "")
+	if err != nil {
+		t.Fatal(err)
 	}
+
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		logger:           logger,
+		store:            mockStore,
+		cacheStorage:     mockCacheStore,
+		authenticator:    testAuth,
+		rateLimiter:      rateLimiter,
+		passwordPolicy:   passwordPolicy,
+		blobs:            blobs,
+		mailer:           &mailer.MockClient{},
+		magicLinkLimiter: ratelimiter.NewFixedWindowRateLimiter(3, time.Minute),
+	}
+
+	//every method, so all of them can be tested
+	app.authChain, err = app.newAuthChain([]string{"bearer", "api_key", "session_cookie", "basic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return app
 }
 
 func excuteRequest(req *http.Request, mux *chi.Mux) *httptest.ResponseRecorder {
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"net/http"
+	"social/internal/mailer"
 	"social/internal/store"
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
 type userKey string
 
-const userCtx userKey = "user"
+const (
+	userCtx      userKey = "user"
+	principalCtx userKey = "principal"
+)
 
 // GetUser godoc
 //
@@ -167,7 +175,7 @@ func (app *application) unfollowUserHandler(w http.ResponseWriter, r *http.Reque
 func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
 	token := chi.URLParam(r, "token")
 
-	err := app.store.Users.Activate(r.Context(), token)
+	userID, err := app.store.Users.Activate(r.Context(), token)
 	if err != nil {
 		switch err {
 		case store.ErrNotFound:
@@ -178,6 +186,8 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	app.audit(r, store.AuditEvent{ActorID: userID, Action: store.AuditUserActivated, TargetType: "user", TargetID: userID})
+
 	err = app.jsonResponse(w, http.StatusNoContent, "")
 	if err != nil {
 		app.internalServerError(w, r, err)
@@ -186,6 +196,84 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 }
 
+type ResendActivationPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// ResendActivation godoc
+//
+//	@Summary		Resends the activation email
+//	@Description	Sends a new invitation link to an account which isn't activated yet, earlier links stop working.
+//	@Description	The response is the same whether such an account exists or not
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ResendActivationPayload	true	"Account email"
+//	@Success		202		{string}	string					"Activation email sent if the account exists"
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/users/activation/resend [post]
+func (app *application) resendActivationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResendActivationPayload
+	err := readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	err = Validate.Struct(payload)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	//unknown and already active emails get this answer too, it mustn't tell which emails are registered
+	msg := "if an account waiting for activation exists for that email, a new activation link has been sent"
+
+	plainToken := uuid.New().String()
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	user, err := app.store.Users.RotateInvitation(r.Context(), payload.Email, hashToken, app.config.mail.exp)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			if err := app.jsonResponse(w, http.StatusAccepted, msg); err != nil {
+				app.internalServerError(w, r, err)
+			}
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	activationURL := fmt.Sprintf("%s/confirm/%s", app.config.frontendURL, plainToken)
+
+	isProdEnv := app.config.env == "production"
+	vars := struct {
+		Username      string
+		ActivationURL string
+	}{
+		Username:      user.Username,
+		ActivationURL: activationURL,
+	}
+
+	app.background(func() {
+		status, err := app.mailer.Send(mailer.UserWelcomeTemplate, user.Username, user.Email, vars, !isProdEnv)
+		if err != nil {
+			app.logger.Errorw("error resending welcome email", "user", user.ID, "error", err)
+			return
+		}
+
+		app.logger.Infow("Email sent", "status code", status)
+	})
+
+	if err := app.jsonResponse(w, http.StatusAccepted, msg); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
 // exercise 35
 // func (app *application) userContextMiddleware(next http.Handler) http.Handler {
 // 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
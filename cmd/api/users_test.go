@@ -1,10 +1,29 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"path"
+	"reflect"
+	"social/internal/mailer"
+	"social/internal/store"
+	"strings"
 	"testing"
+	"time"
 )
 
+// emailLink returns the token at the end of the link in field of the last email sent with template
+func emailLink(t *testing.T, mails *mailer.MockClient, template, field string) string {
+	t.Helper()
+
+	data := mails.Last(template).Data
+	if data == nil {
+		t.Fatalf("no %s email was sent", template)
+	}
+
+	return path.Base(reflect.ValueOf(data).FieldByName(field).String())
+}
+
 // ex 62 ***Test for not allowing unauthenticated users 401 ***
 func TestGetUser(t *testing.T) {
 
@@ -63,3 +82,204 @@ func TestGetUser(t *testing.T) {
 	})
 
 }
+
+func TestResendActivation(t *testing.T) {
+	app := newTestApplication(t, config{})
+	mux := app.mount()
+
+	t.Run("should not tell if the email is registered", func(t *testing.T) {
+		body := strings.NewReader(`{"email": "nobody@example.com"}`)
+		req, err := http.NewRequest(http.MethodPost, "/v1/users/activation/resend", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusAccepted, rr.Code)
+	})
+
+	t.Run("should reject an invalid email", func(t *testing.T) {
+		body := strings.NewReader(`{"email": "not-an-email"}`)
+		req, err := http.NewRequest(http.MethodPost, "/v1/users/activation/resend", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusBadRequest, rr.Code)
+	})
+
+	app.config.mail.exp = time.Hour
+	users := app.store.Users.(*store.MockUserStore)
+	users.Email = "gopher@example.com"
+	mails := app.mailer.(*mailer.MockClient)
+
+	resend := func() {
+		body := strings.NewReader(`{"email": "gopher@example.com"}`)
+		req, err := http.NewRequest(http.MethodPost, "/v1/users/activation/resend", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+		checkResponseCode(t, http.StatusAccepted, rr.Code)
+		//the email goes out in the background
+		app.wg.Wait()
+	}
+
+	activate := func(token string) int {
+		req, err := http.NewRequest(http.MethodPut, "/v1/users/activate/"+token, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return excuteRequest(req, mux).Code
+	}
+
+	t.Run("should replace the invitation with a new one", func(t *testing.T) {
+		resend()
+		first := emailLink(t, mails, mailer.UserWelcomeTemplate, "ActivationURL")
+
+		resend()
+		second := emailLink(t, mails, mailer.UserWelcomeTemplate, "ActivationURL")
+
+		if first == second {
+			t.Fatal("expected a new activation link")
+		}
+		if n := users.Invitations(); n != 1 {
+			t.Errorf("expected 1 invitation, got %d", n)
+		}
+
+		checkResponseCode(t, http.StatusBadRequest, activate(first))
+		checkResponseCode(t, http.StatusNoContent, activate(second))
+	})
+
+	t.Run("should not send anything once the account is active", func(t *testing.T) {
+		sent := mails.Count(mailer.UserWelcomeTemplate)
+		resend()
+
+		if n := mails.Count(mailer.UserWelcomeTemplate); n != sent {
+			t.Errorf("expected no new activation email, got %d", n-sent)
+		}
+	})
+}
+
+func TestPurgeUnactivatedUsers(t *testing.T) {
+	app := newTestApplication(t, config{})
+	app.config.jobs.unactivatedGrace = 48 * time.Hour
+
+	users := app.store.Users.(*store.MockUserStore)
+	users.Email = "gopher@example.com"
+
+	if _, err := users.RotateInvitation(context.Background(), "gopher@example.com", "expired", -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.purgeUnactivatedUsers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if age := time.Since(users.PurgedBefore); age < 48*time.Hour || age > 49*time.Hour {
+		t.Errorf("expected accounts older than the grace period to be purged, got %v", users.PurgedBefore)
+	}
+	if n := users.Invitations(); n != 0 {
+		t.Errorf("expected the expired invitation to be purged, got %d", n)
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	app := newTestApplication(t, config{})
+	mux := app.mount()
+
+	t.Run("should not allow unauthenticated requests", func(t *testing.T) {
+		body := strings.NewReader(`{"email": "new@example.com", "password": "secret"}`)
+		req, err := http.NewRequest(http.MethodPatch, "/v1/users/me/email", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := excuteRequest(req, mux)
+
+		checkResponseCode(t, http.StatusUnauthorized, rr.Code)
+	})
+	testToken, err := app.authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.config.mail.resetExp = time.Hour
+	users := app.store.Users.(*store.MockUserStore)
+	users.Email = "gopher@example.com"
+	users.Password = "correct-horse"
+	mails := app.mailer.(*mailer.MockClient)
+
+	confirm := func(token string) int {
+		req, err := http.NewRequest(http.MethodPut, "/v1/users/email/confirm/"+token, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return excuteRequest(req, mux).Code
+	}
+
+	email := func() string {
+		user, err := users.GetByID(context.Background(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.Email
+	}
+
+	var token string
+
+	t.Run("should send a confirmation link to the new email", func(t *testing.T) {
+		body := strings.NewReader(`{"email": "new@example.com", "password": "correct-horse"}`)
+		req, err := http.NewRequest(http.MethodPatch, "/v1/users/me/email", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		rr := excuteRequest(req, mux)
+		checkResponseCode(t, http.StatusAccepted, rr.Code)
+		app.wg.Wait()
+
+		if to := mails.Last(mailer.EmailChangeConfirmTemplate).Email; to != "new@example.com" {
+			t.Errorf("expected the confirmation at new@example.com, got %q", to)
+		}
+		if to := mails.Last(mailer.EmailChangeNoticeTemplate).Email; to != "gopher@example.com" {
+			t.Errorf("expected the notice at gopher@example.com, got %q", to)
+		}
+		if got := email(); got != "gopher@example.com" {
+			t.Errorf("expected the email to stay until confirmed, got %s", got)
+		}
+
+		token = emailLink(t, mails, mailer.EmailChangeConfirmTemplate, "ConfirmURL")
+	})
+
+	t.Run("should swap the email once confirmed", func(t *testing.T) {
+		checkResponseCode(t, http.StatusNoContent, confirm(token))
+
+		if got := email(); got != "new@example.com" {
+			t.Errorf("expected new@example.com, got %s", got)
+		}
+	})
+
+	t.Run("should reject a reused token", func(t *testing.T) {
+		checkResponseCode(t, http.StatusBadRequest, confirm(token))
+	})
+
+	t.Run("should reject an expired token", func(t *testing.T) {
+		err := users.RequestEmailChange(context.Background(), 0, "late@example.com", "expired-token", -time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkResponseCode(t, http.StatusBadRequest, confirm("expired-token"))
+		if got := email(); got != "new@example.com" {
+			t.Errorf("expected the email to stay new@example.com, got %s", got)
+		}
+	})
+}
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id bigserial PRIMARY KEY,
+    token bytea UNIQUE NOT NULL,
+    user_id bigint NOT NULL,
+    family_id uuid NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL,
+    used_at timestamp(0) with time zone,
+    revoked_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+/*
+Refresh tokens are opaque random strings, we only keep the sha256 hash of them like we do for user_invitations.
+Every login starts a new family_id, and every refresh rotates the token inside the same family: the old row gets
+used_at set and a new row is inserted. If a token with used_at already set is presented again somebody has a copy
+of it, so the whole family is revoked (revoked_at) which also kills the access tokens carrying that family as sid.
+*/
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS user_tokens;
+
+ALTER TABLE
+    users DROP COLUMN locked_until;
+
+ALTER TABLE
+    users DROP COLUMN failed_login_attempts;
@@ -0,0 +1,26 @@
+ALTER TABLE
+    users
+ADD
+    COLUMN failed_login_attempts INT NOT NULL DEFAULT 0;
+
+ALTER TABLE
+    users
+ADD
+    COLUMN locked_until timestamp(0) with time zone;
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+    token bytea PRIMARY KEY,
+    user_id bigint NOT NULL,
+    scope VARCHAR(50) NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id_scope ON user_tokens (user_id, scope);
+
+/*
+user_tokens works exactly like user_invitations (sha256 of the plaintext token + expiry) but carries a scope,
+so the same table can hold account unlock links and any other single use link we email to an existing user.
+*/
@@ -0,0 +1,27 @@
+ALTER TABLE
+    users
+ADD
+    COLUMN totp_secret TEXT;
+
+ALTER TABLE
+    users
+ADD
+    COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- last accepted time step, a code can't be used twice within its 30 seconds window
+ALTER TABLE
+    users
+ADD
+    COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    code bytea NOT NULL,
+    used_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user_id ON user_recovery_codes (user_id);
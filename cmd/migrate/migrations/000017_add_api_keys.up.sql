@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key bytea UNIQUE NOT NULL,
+    scopes VARCHAR(50) [] NOT NULL DEFAULT '{}',
+    expires_at timestamp(0) with time zone,
+    last_used_at timestamp(0) with time zone,
+    revoked_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys (user_id);
+
+/*
+Like every other token we keep only the sha256 of the key. prefix is the first characters of the plaintext key,
+it is safe to show and lets users tell their keys apart in the list.
+*/
@@ -0,0 +1,13 @@
+-- only used when Redis is disabled, otherwise the denylist lives in Redis
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expiry ON revoked_tokens (expiry);
+
+-- "log out everywhere": access tokens issued before this are rejected
+ALTER TABLE
+    users
+ADD
+    COLUMN tokens_revoked_before timestamp(0) with time zone;
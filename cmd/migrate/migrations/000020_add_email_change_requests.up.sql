@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS email_change_requests (
+    token bytea PRIMARY KEY,
+    user_id bigint NOT NULL,
+    new_email citext NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_change_requests_user_id ON email_change_requests (user_id);
@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id bigint NOT NULL,
+    permission_id bigint NOT NULL,
+
+    PRIMARY KEY (role_id, permission_id),
+    FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE,
+    FOREIGN KEY (permission_id) REFERENCES permissions (id) ON DELETE CASCADE
+);
+
+INSERT INTO
+  permissions (name, description)
+VALUES
+  ('posts.create', 'Create posts'),
+  ('posts.update.any', 'Update posts of other users'),
+  ('posts.delete.any', 'Delete posts of other users'),
+  ('comments.create', 'Comment on posts'),
+  ('comments.delete.any', 'Delete comments of other users'),
+  ('users.ban', 'Ban other users');
+
+-- the same powers the levels gave: moderator (2) could update any post, admin (3) also delete them
+INSERT INTO
+  role_permissions (role_id, permission_id)
+SELECT
+  r.id,
+  p.id
+FROM
+  roles r
+  JOIN permissions p ON (
+    (r.name = 'user' AND p.name IN ('posts.create', 'comments.create'))
+    OR (r.name = 'moderator' AND p.name IN ('posts.create', 'comments.create', 'posts.update.any', 'comments.delete.any'))
+    OR r.name = 'admin'
+  );
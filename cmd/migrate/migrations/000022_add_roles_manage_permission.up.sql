@@ -0,0 +1,16 @@
+INSERT INTO
+  permissions (name, description)
+VALUES
+  ('roles.manage', 'Create and update roles and assign them to users');
+
+INSERT INTO
+  role_permissions (role_id, permission_id)
+SELECT
+  r.id,
+  p.id
+FROM
+  roles r,
+  permissions p
+WHERE
+  r.name = 'admin'
+  AND p.name = 'roles.manage';
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id uuid PRIMARY KEY,
+    user_id bigint NOT NULL,
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    ip VARCHAR(100) NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_seen_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+/*
+A session is one login, its id is the family_id of the refresh tokens issued from it and the sid claim of the
+access tokens. Whether it is still active is not stored here: it is as long as its family has a refresh token
+which is unused, unrevoked and unexpired, so logout, reuse detection and revoking a session all go through
+revoking the family.
+*/
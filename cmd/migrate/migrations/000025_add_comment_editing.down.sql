@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE name = 'comments.update.any';
+
+DROP INDEX IF EXISTS idx_comments_post_id_id;
+
+ALTER TABLE comments DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,24 @@
+ALTER TABLE comments ADD COLUMN updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+UPDATE comments SET updated_at = created_at;
+
+-- comments are paged newest first per post
+CREATE INDEX IF NOT EXISTS idx_comments_post_id_id ON comments (post_id, id DESC);
+
+INSERT INTO
+  permissions (name, description)
+VALUES
+  ('comments.update.any', 'Update comments of other users');
+
+-- moderators can remove a comment but only admins put words in somebody else's mouth
+INSERT INTO
+  role_permissions (role_id, permission_id)
+SELECT
+  r.id,
+  p.id
+FROM
+  roles r,
+  permissions p
+WHERE
+  r.name = 'admin'
+  AND p.name = 'comments.update.any';
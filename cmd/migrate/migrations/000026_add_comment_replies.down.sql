@@ -0,0 +1,10 @@
+-- there is no flat version of a thread, replies go along with the column
+DELETE FROM comments WHERE parent_id IS NOT NULL;
+
+DELETE FROM comments WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_comments_parent_id_id;
+
+ALTER TABLE comments DROP COLUMN IF EXISTS deleted_at;
+
+ALTER TABLE comments DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,6 @@
+ALTER TABLE comments ADD COLUMN parent_id bigint REFERENCES comments (id) ON DELETE CASCADE;
+
+-- set when a comment with replies is deleted, the row stays as a placeholder
+ALTER TABLE comments ADD COLUMN deleted_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id_id ON comments (parent_id, id DESC) WHERE parent_id IS NOT NULL;
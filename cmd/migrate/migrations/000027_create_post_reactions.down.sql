@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS post_reaction_counts;
+
+DROP TABLE IF EXISTS post_reactions;
+
+DROP FUNCTION IF EXISTS post_reaction_counts_update;
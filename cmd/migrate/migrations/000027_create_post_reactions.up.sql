@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS post_reactions (
+    id bigserial PRIMARY KEY,
+    post_id bigint NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    kind VARCHAR(32) NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    UNIQUE (post_id, user_id, kind)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_reactions_post_id_id ON post_reactions (post_id, id DESC);
+
+-- the feed reads these for every post, counting post_reactions there would get slower with every like
+CREATE TABLE IF NOT EXISTS post_reaction_counts (
+    post_id bigint NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    kind VARCHAR(32) NOT NULL,
+    count bigint NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (post_id, kind)
+);
+
+CREATE OR REPLACE FUNCTION post_reaction_counts_update() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO post_reaction_counts (post_id, kind, count)
+        VALUES (NEW.post_id, NEW.kind, 1)
+        ON CONFLICT (post_id, kind) DO UPDATE SET count = post_reaction_counts.count + 1;
+        RETURN NEW;
+    END IF;
+
+    UPDATE post_reaction_counts SET count = count - 1
+    WHERE post_id = OLD.post_id AND kind = OLD.kind;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER post_reaction_counts_update
+AFTER INSERT OR DELETE ON post_reactions
+FOR EACH ROW EXECUTE FUNCTION post_reaction_counts_update();
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS post_revisions;
+
+DROP FUNCTION IF EXISTS post_revisions_immutable;
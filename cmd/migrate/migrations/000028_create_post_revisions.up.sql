@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS post_revisions (
+    id bigserial PRIMARY KEY,
+    post_id bigint NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    title TEXT NOT NULL,
+    content TEXT NOT NULL,
+    tags VARCHAR(100) [],
+    -- like audit_events no foreign key, the history stays when the editor is gone
+    editor_id bigint,
+    -- the version this one was restored from, if any
+    restored_from INT,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    UNIQUE (post_id, version)
+);
+
+-- rows go away with their post but are never changed
+CREATE OR REPLACE FUNCTION post_revisions_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'post_revisions are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER post_revisions_immutable
+BEFORE UPDATE ON post_revisions
+FOR EACH ROW EXECUTE FUNCTION post_revisions_immutable();
+
+-- what came before is lost, history starts with the posts as they are now
+INSERT INTO
+  post_revisions (post_id, version, title, content, tags, editor_id, created_at)
+SELECT
+  id,
+  COALESCE(version, 0),
+  title,
+  content,
+  tags,
+  user_id,
+  updated_at
+FROM
+  posts;
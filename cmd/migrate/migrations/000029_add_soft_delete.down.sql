@@ -0,0 +1,16 @@
+-- rows that were only soft deleted come back
+DELETE FROM permissions WHERE name = 'content.restore';
+
+DROP TRIGGER IF EXISTS users_require_soft_delete ON users;
+DROP TRIGGER IF EXISTS comments_require_soft_delete ON comments;
+DROP TRIGGER IF EXISTS posts_require_soft_delete ON posts;
+
+DROP FUNCTION IF EXISTS require_soft_delete;
+
+DROP INDEX IF EXISTS idx_comments_deleted_at;
+DROP INDEX IF EXISTS idx_posts_deleted_at;
+DROP INDEX IF EXISTS idx_users_deleted_at;
+
+ALTER TABLE posts DROP COLUMN IF EXISTS deleted_at;
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
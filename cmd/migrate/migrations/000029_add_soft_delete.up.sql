@@ -0,0 +1,51 @@
+ALTER TABLE users ADD COLUMN deleted_at timestamp(0) with time zone;
+
+ALTER TABLE posts ADD COLUMN deleted_at timestamp(0) with time zone;
+
+-- comments got deleted_at with the threads in 000026, from now on it keeps the content for restoring
+
+-- the retention job looks for rows deleted long enough ago
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_posts_deleted_at ON posts (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_comments_deleted_at ON comments (deleted_at) WHERE deleted_at IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION require_soft_delete() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION '% % has to be soft deleted first', TG_TABLE_NAME, OLD.id;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER posts_require_soft_delete
+BEFORE DELETE ON posts
+FOR EACH ROW WHEN (OLD.deleted_at IS NULL) EXECUTE FUNCTION require_soft_delete();
+
+CREATE TRIGGER comments_require_soft_delete
+BEFORE DELETE ON comments
+FOR EACH ROW WHEN (OLD.deleted_at IS NULL) EXECUTE FUNCTION require_soft_delete();
+
+-- accounts that never activated have nothing worth keeping
+CREATE TRIGGER users_require_soft_delete
+BEFORE DELETE ON users
+FOR EACH ROW WHEN (OLD.deleted_at IS NULL AND OLD.is_active) EXECUTE FUNCTION require_soft_delete();
+
+INSERT INTO
+  permissions (name, description)
+VALUES
+  ('content.restore', 'Restore deleted posts, comments and users');
+
+INSERT INTO
+  role_permissions (role_id, permission_id)
+SELECT
+  r.id,
+  p.id
+FROM
+  roles r,
+  permissions p
+WHERE
+  r.name = 'admin'
+  AND p.name = 'content.restore';
+
+/*
+Deleting only sets deleted_at, the ON DELETE CASCADE foreign keys on users and posts (followers, tokens, reactions,
+revisions...) fire when the retention job hard deletes the row. The triggers make sure nothing else gets there first.
+*/
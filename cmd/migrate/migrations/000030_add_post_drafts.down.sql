@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_posts_drafts;
+
+DROP INDEX IF EXISTS idx_posts_publish_at;
+
+-- careful, drafts that are still around show up in feeds like any other post afterwards
+ALTER TABLE posts DROP COLUMN IF EXISTS publish_at;
+
+ALTER TABLE posts DROP COLUMN IF EXISTS published_at;
@@ -0,0 +1,13 @@
+-- NULL while the post is a draft, feeds only show published posts ordered by it
+ALTER TABLE posts ADD COLUMN published_at timestamp(0) with time zone;
+
+-- a scheduled draft, the publisher job publishes it once this is due
+ALTER TABLE posts ADD COLUMN publish_at timestamp(0) with time zone;
+
+-- everything so far was published the moment it was created
+UPDATE posts SET published_at = created_at;
+
+CREATE INDEX IF NOT EXISTS idx_posts_publish_at ON posts (publish_at) WHERE published_at IS NULL AND publish_at IS NOT NULL;
+
+-- GET /users/me/drafts
+CREATE INDEX IF NOT EXISTS idx_posts_drafts ON posts (user_id, id DESC) WHERE published_at IS NULL;
@@ -0,0 +1,2 @@
+-- the blobs stay in the blob store
+DROP TABLE IF EXISTS media;
@@ -0,0 +1,19 @@
+-- uploaded images, the bytes live in the blob store under key and thumbnail_key
+CREATE TABLE IF NOT EXISTS media (
+    id bigserial PRIMARY KEY,
+    -- both are set to NULL instead of cascading, the orphan job then deletes the row with its blobs
+    user_id bigint REFERENCES users (id) ON DELETE SET NULL,
+    post_id bigint REFERENCES posts (id) ON DELETE SET NULL,
+    key text NOT NULL UNIQUE,
+    thumbnail_key text NOT NULL UNIQUE,
+    content_type varchar(32) NOT NULL,
+    size bigint NOT NULL,
+    width int NOT NULL,
+    height int NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_post_id ON media (post_id, id) WHERE post_id IS NOT NULL;
+
+-- uploads nobody attached to a post
+CREATE INDEX IF NOT EXISTS idx_media_orphans ON media (created_at) WHERE post_id IS NULL;
@@ -0,0 +1,5 @@
+-- the version a post had when it was published, edits made while it was a draft don't count as edited
+ALTER TABLE posts ADD COLUMN published_version int;
+
+-- before this only updates bumped the version, so published posts keep their edited flag
+UPDATE posts SET published_version = 0 WHERE published_at IS NOT NULL;
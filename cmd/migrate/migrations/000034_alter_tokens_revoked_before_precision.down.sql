@@ -0,0 +1,4 @@
+ALTER TABLE
+    users
+ALTER COLUMN
+    tokens_revoked_before TYPE timestamp(0) with time zone;
@@ -0,0 +1,5 @@
+-- timestamp(0) rounds the watermark up to the next second, which rejected tokens from right after it
+ALTER TABLE
+    users
+ALTER COLUMN
+    tokens_revoked_before TYPE timestamp with time zone;
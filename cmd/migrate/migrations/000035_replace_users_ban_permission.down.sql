@@ -0,0 +1,20 @@
+DELETE FROM
+  permissions
+WHERE
+  name = 'auth.mfa.required';
+
+INSERT INTO
+  permissions (name, description)
+VALUES
+  ('users.ban', 'Ban other users') ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO
+  role_permissions (role_id, permission_id)
+SELECT
+  r.id,
+  p.id
+FROM
+  roles r
+  JOIN permissions p ON p.name = 'users.ban'
+WHERE
+  r.name = 'admin' ON CONFLICT DO NOTHING;
@@ -0,0 +1,11 @@
+-- nothing checks users.ban, it only made admins look like they could ban
+DELETE FROM
+  permissions
+WHERE
+  name = 'users.ban';
+
+-- opt in per role, AUTH_MFA_REQUIRED_ROLES covers the roles chosen at deploy time
+INSERT INTO
+  permissions (name, description)
+VALUES
+  ('auth.mfa.required', 'Has to log in with two-factor authentication') ON CONFLICT (name) DO NOTHING;
@@ -16,6 +16,6 @@ func main() {
 
 	defer conn.Close()
 
-	store := store.NewStorage(conn)
+	store := store.NewStorage(conn, store.DefaultArgon2Params(), env.GetString("AUTH_MFA_SECRET_KEY", "example"))
 	db.Seed(store, conn)
 }
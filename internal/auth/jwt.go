@@ -2,6 +2,7 @@ package auth
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -10,41 +11,104 @@ type JWTAuthenticator struct {
 	secret string //Keep this hidden/safe/secure in env file, its a secret
 	aud    string
 	iss    string
+	//keys is only set for RS256/EdDSA, then secret is not used at all
+	keys *KeySet
 }
 
+// NewJWTAuthenticator signs with a single shared HS256 secret
 func NewJWTAuthenticator(secret, aud, iss string) *JWTAuthenticator {
-	return &JWTAuthenticator{secret, iss, aud}
+	return &JWTAuthenticator{secret: secret, aud: aud, iss: iss}
+}
+
+// NewKeySetAuthenticator signs with the private keys of the key set, anybody with the JWKS can verify
+func NewKeySetAuthenticator(keys *KeySet, aud, iss string) *JWTAuthenticator {
+	return &JWTAuthenticator{keys: keys, aud: aud, iss: iss}
 }
 
 // used to generate tokenstring at /authentication/token route in createTokenHandler function handler
 // it is present in api/auth.go file
 func (a *JWTAuthenticator) GenerateToken(claims jwt.Claims) (string, error) {
-	//create a new claim
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if a.keys == nil {
+		//create a new claim
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+		//convert token into string using secret key and need to convert into byte slice
+		tokenString, err := token.SignedString([]byte(a.secret))
+		if err != nil {
+			return "", err
+		}
+		return tokenString, err
+	}
 
-	//convert token into string using secret key and need to convert into byte slice
-	tokenString, err := token.SignedString([]byte(a.secret))
+	key, err := a.keys.signingKey(time.Now())
 	if err != nil {
 		return "", err
 	}
-	return tokenString, err
+
+	//kid tells the verifier which key of the JWKS to use
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.private)
 }
 
 // ex 52 takes in tokenstring and gives jwttoken, used in AuthTokenMiddleware function in api/middleware.go
 func (a *JWTAuthenticator) ValidateToken(token string) (*jwt.Token, error) {
+	validMethods := []string{jwt.SigningMethodHS256.Name}
+	if a.keys != nil {
+		validMethods = []string{a.keys.algorithm}
+	}
+
 	//using parse method and token function to validate token
-	return jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+	jwtToken, err := jwt.Parse(token, a.keyFunc,
+		//doing some extra checks to make our app more safier
+		jwt.WithExpirationRequired(), //first
+		jwt.WithAudience(a.aud),      //second
+		jwt.WithIssuer(a.iss),
+		jwt.WithValidMethods(validMethods), //highly encouraged and recommended to add
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	//a token without sid can't be revoked, so we don't accept it at all
+	claims, ok := jwtToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrMissingSession
+	}
+	if sid, _ := claims["sid"].(string); sid == "" {
+		return nil, ErrMissingSession
+	}
+
+	return jwtToken, nil
+}
+
+func (a *JWTAuthenticator) keyFunc(t *jwt.Token) (interface{}, error) {
+	if a.keys == nil {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
 		}
 
 		return []byte(a.secret), nil
-	},
-		//doing some extra checks to make our app more safier
-		jwt.WithExpirationRequired(), //first
-		jwt.WithAudience(a.aud),      //second
-		jwt.WithIssuer(a.aud),
-		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}), //highly encouraged and recommended to add
-	)
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	key, err := a.keys.verificationKey(kid, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if t.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+	}
+
+	return key.private.Public(), nil
+}
+
+// JWKS is empty for HS256, a shared secret must never be published
+func (a *JWTAuthenticator) JWKS() JWKS {
+	if a.keys == nil {
+		return JWKS{Keys: []JWK{}}
+	}
 
+	return a.keys.JWKS()
 }
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrNoSigningKey = errors.New("no active signing key")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+// SigningKey is one private key loaded from <kid>.pem. The optional PEM headers Not-Before and Not-After
+// (RFC 3339) schedule the rotation: a key signs from Not-Before on, and verifies until Not-After
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	NotBefore time.Time
+	NotAfter  time.Time
+	private   crypto.Signer
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// retired keys are not used for anything anymore, zero NotAfter never retires
+func (k *SigningKey) retired(now time.Time) bool {
+	return !k.NotAfter.IsZero() && !now.Before(k.NotAfter)
+}
+
+// KeySet holds every key found in a directory, it is safe to Reload while tokens are signed and validated
+type KeySet struct {
+	mu        sync.RWMutex
+	dir       string
+	algorithm string
+	keys      map[string]*SigningKey
+}
+
+// LoadKeySet reads all *.pem files in dir, every key has to match algorithm (RS256 or EdDSA)
+func LoadKeySet(dir, algorithm string) (*KeySet, error) {
+	if algorithm != jwt.SigningMethodRS256.Alg() && algorithm != jwt.SigningMethodEdDSA.Alg() {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	ks := &KeySet{dir: dir, algorithm: algorithm}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+
+	//starting without anything to sign with would only fail later on the first login
+	if _, err := ks.signingKey(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// Reload reads the directory again, dropping a new <kid>.pem file in there is how keys get rotated in
+func (ks *KeySet) Reload() error {
+	files, err := filepath.Glob(filepath.Join(ks.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*SigningKey, len(files))
+	for _, file := range files {
+		key, err := ks.loadKey(file)
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", file, err)
+		}
+		keys[key.ID] = key
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.mu.Unlock()
+
+	return nil
+}
+
+func (ks *KeySet) loadKey(file string) (*SigningKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{
+		ID:        strings.TrimSuffix(filepath.Base(file), ".pem"),
+		Algorithm: ks.algorithm,
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if ks.algorithm != jwt.SigningMethodRS256.Alg() {
+			return nil, fmt.Errorf("RSA key can't be used for %s", ks.algorithm)
+		}
+		key.private = k
+	case ed25519.PrivateKey:
+		if ks.algorithm != jwt.SigningMethodEdDSA.Alg() {
+			return nil, fmt.Errorf("Ed25519 key can't be used for %s", ks.algorithm)
+		}
+		key.private = k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	if v, ok := block.Headers["Not-Before"]; ok {
+		if key.NotBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := block.Headers["Not-After"]; ok {
+		if key.NotAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// signingKey is the newest key which is already active and not retired yet
+func (ks *KeySet) signingKey(now time.Time) (*SigningKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	var current *SigningKey
+	for _, key := range ks.keys {
+		if key.NotBefore.After(now) || key.retired(now) {
+			continue
+		}
+		if current == nil || key.NotBefore.After(current.NotBefore) ||
+			(key.NotBefore.Equal(current.NotBefore) && key.ID > current.ID) {
+			current = key
+		}
+	}
+
+	if current == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	return current, nil
+}
+
+// verificationKey still accepts keys which were rotated out for signing, until their Not-After
+func (ks *KeySet) verificationKey(kid string, now time.Time) (*SigningKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, ok := ks.keys[kid]
+	if !ok || key.retired(now) {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
+
+// JWK is the public part of a signing key as described in RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// PublicKey turns a published JWK back into a key jwt can verify with, used for the OIDC provider keys
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes every key that isn't retired, including scheduled ones so verifiers can fetch them early
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := time.Now()
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		if key.retired(now) {
+			continue
+		}
+
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Algorithm}
+		switch pub := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
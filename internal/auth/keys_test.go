@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeKey stores a new private key as <kid>.pem in dir, zero times leave the header out
+func writeKey(t *testing.T, dir, kid, alg string, notBefore, notAfter time.Time) {
+	t.Helper()
+
+	var private any
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		private = key
+	case jwt.SigningMethodEdDSA.Alg():
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		private = key
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := &pem.Block{Type: "PRIVATE KEY", Headers: map[string]string{}, Bytes: der}
+	if !notBefore.IsZero() {
+		block.Headers["Not-Before"] = notBefore.Format(time.RFC3339)
+	}
+	if !notAfter.IsZero() {
+		block.Headers["Not-After"] = notAfter.Format(time.RFC3339)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// signWith signs a token with one specific key of the set, like GenerateToken did while it was the newest
+func signWith(t *testing.T, ks *KeySet, kid string) string {
+	t.Helper()
+
+	key := ks.keys[kid]
+	token := jwt.NewWithClaims(key.method(), jwt.MapClaims{
+		"sub": 1,
+		"sid": "test-session",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"aud": "test-aud",
+		"iss": "test-aud",
+	})
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestKeySet(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		alg string
+		kty string
+	}{
+		{alg: jwt.SigningMethodRS256.Alg(), kty: "RSA"},
+		{alg: jwt.SigningMethodEdDSA.Alg(), kty: "OKP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			dir := t.TempDir()
+			//old is rotated out but still verifies for an hour, expired is gone, next isn't active yet
+			writeKey(t, dir, "expired", tt.alg, now.Add(-72*time.Hour), now.Add(-time.Hour))
+			writeKey(t, dir, "old", tt.alg, now.Add(-48*time.Hour), now.Add(time.Hour))
+			writeKey(t, dir, "current", tt.alg, now.Add(-24*time.Hour), time.Time{})
+			writeKey(t, dir, "next", tt.alg, now.Add(24*time.Hour), time.Time{})
+
+			ks, err := LoadKeySet(dir, tt.alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			authenticator := NewKeySetAuthenticator(ks, "test-aud", "test-aud")
+
+			t.Run("should sign with the newest active key", func(t *testing.T) {
+				token, err := authenticator.GenerateToken(jwt.MapClaims{
+					"sid": "test-session",
+					"exp": now.Add(time.Hour).Unix(),
+					"aud": "test-aud",
+					"iss": "test-aud",
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				parsed, err := authenticator.ValidateToken(token)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if kid := parsed.Header["kid"]; kid != "current" {
+					t.Errorf("expected kid current, got %v", kid)
+				}
+			})
+
+			t.Run("should verify a rotated key until it ages out", func(t *testing.T) {
+				if _, err := authenticator.ValidateToken(signWith(t, ks, "old")); err != nil {
+					t.Errorf("expected the old key to verify, got %v", err)
+				}
+				if _, err := ks.verificationKey("old", now.Add(2*time.Hour)); !errors.Is(err, ErrUnknownKey) {
+					t.Errorf("expected %v after Not-After, got %v", ErrUnknownKey, err)
+				}
+				if _, err := authenticator.ValidateToken(signWith(t, ks, "expired")); !errors.Is(err, ErrUnknownKey) {
+					t.Errorf("expected %v for a retired key, got %v", ErrUnknownKey, err)
+				}
+			})
+
+			t.Run("should switch to a scheduled key once it is active", func(t *testing.T) {
+				key, err := ks.signingKey(now.Add(25 * time.Hour))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if key.ID != "next" {
+					t.Errorf("expected next, got %s", key.ID)
+				}
+			})
+
+			t.Run("should publish only public parts of keys which aren't retired", func(t *testing.T) {
+				set := ks.JWKS()
+
+				kids := make([]string, 0, len(set.Keys))
+				for _, jwk := range set.Keys {
+					kids = append(kids, jwk.Kid)
+
+					if jwk.Kty != tt.kty || jwk.Alg != tt.alg || jwk.Use != "sig" {
+						t.Errorf("unexpected jwk %+v", jwk)
+					}
+
+					pub, err := jwk.PublicKey()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !ks.keys[jwk.Kid].private.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pub) {
+						t.Errorf("jwk %s doesn't match the public key", jwk.Kid)
+					}
+				}
+
+				sort.Strings(kids)
+				if got := strings.Join(kids, ","); got != "current,next,old" {
+					t.Errorf("expected current,next,old, got %s", got)
+				}
+
+				data, err := json.Marshal(set)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, private := range []string{`"d"`, `"p"`, `"q"`, `"dp"`, `"dq"`, `"qi"`} {
+					if strings.Contains(string(data), private) {
+						t.Errorf("JWKS contains private member %s", private)
+					}
+				}
+				if tt.kty == "OKP" {
+					//an Ed25519 private key is seed and public key, only the 32 public bytes may be published
+					for _, jwk := range set.Keys {
+						x, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+						if len(x) != ed25519.PublicKeySize {
+							t.Errorf("expected %d bytes in x, got %d", ed25519.PublicKeySize, len(x))
+						}
+					}
+				}
+			})
+
+			t.Run("should pick up a new key on reload", func(t *testing.T) {
+				writeKey(t, dir, "rotated", tt.alg, now.Add(-time.Minute), time.Time{})
+				if err := ks.Reload(); err != nil {
+					t.Fatal(err)
+				}
+
+				key, err := ks.signingKey(now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if key.ID != "rotated" {
+					t.Errorf("expected rotated, got %s", key.ID)
+				}
+			})
+		})
+	}
+
+	t.Run("should fail without an active key", func(t *testing.T) {
+		dir := t.TempDir()
+		writeKey(t, dir, "next", jwt.SigningMethodEdDSA.Alg(), now.Add(time.Hour), time.Time{})
+
+		if _, err := LoadKeySet(dir, jwt.SigningMethodEdDSA.Alg()); !errors.Is(err, ErrNoSigningKey) {
+			t.Errorf("expected %v, got %v", ErrNoSigningKey, err)
+		}
+	})
+
+	t.Run("should reject a key of another algorithm", func(t *testing.T) {
+		dir := t.TempDir()
+		writeKey(t, dir, "ed", jwt.SigningMethodEdDSA.Alg(), time.Time{}, time.Time{})
+
+		if _, err := LoadKeySet(dir, jwt.SigningMethodRS256.Alg()); err == nil {
+			t.Error("expected an error for an Ed25519 key in an RS256 key set")
+		}
+	})
+}
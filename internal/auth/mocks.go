@@ -16,6 +16,9 @@ var testClaims = jwt.MapClaims{
 	"aud": "test-aud",
 	"iss": "test-aud",
 	"sub": int64(1),
+	"sid": "test-session",
+	"jti": "test-token",
+	"iat": time.Now().Unix(),
 	"exp": time.Now().Add(time.Hour).Unix(),
 }
 
@@ -31,3 +34,7 @@ func (a *TestAuthenticator) ValidateToken(token string) (*jwt.Token, error) {
 		return []byte(secret), nil
 	})
 }
+
+func (a *TestAuthenticator) JWKS() JWKS {
+	return JWKS{Keys: []JWK{}}
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// stubProvider is a minimal OpenID provider: discovery, JWKS and a token endpoint which checks PKCE
+type stubProvider struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &stubProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JWKS{Keys: []JWK{{
+			Kty: "RSA",
+			Kid: "stub",
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != p.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":            p.URL,
+			"aud":            "client",
+			"sub":            "stub-user-1",
+			"email":          "gopher@example.com",
+			"email_verified": true,
+			"nonce":          p.nonce,
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"iat":            time.Now().Unix(),
+		}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "stub"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+// authorize plays the user logging in: it keeps what the login redirect carried, like the real provider would
+func (p *stubProvider) authorize(t *testing.T, authURL string) {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "client" {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	p.challenge = q.Get("code_challenge")
+	p.nonce = q.Get("nonce")
+}
+
+func TestOIDCProvider(t *testing.T) {
+	ctx := context.Background()
+
+	login := func(t *testing.T, stub *stubProvider) (*OIDCProvider, string, string) {
+		provider := NewOIDCProvider(OIDCConfig{
+			Name:         "stub",
+			IssuerURL:    stub.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			RedirectURL:  "http://localhost/callback",
+		}, stub.Client())
+
+		verifier, challenge, err := NewPKCE()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		authURL, err := provider.AuthCodeURL(ctx, "state", "nonce-1", challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stub.authorize(t, authURL)
+
+		return provider, verifier, "nonce-1"
+	}
+
+	t.Run("should return the verified identity", func(t *testing.T) {
+		stub := newStubProvider(t)
+		provider, verifier, nonce := login(t, stub)
+
+		identity, err := provider.Exchange(ctx, "good-code", verifier, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if identity.Subject != "stub-user-1" || identity.Email != "gopher@example.com" || !identity.EmailVerified {
+			t.Errorf("unexpected identity %+v", identity)
+		}
+	})
+
+	t.Run("should accept email_verified sent as a string", func(t *testing.T) {
+		stub := newStubProvider(t)
+		stub.claims = jwt.MapClaims{"email_verified": "false"}
+		provider, verifier, nonce := login(t, stub)
+
+		identity, err := provider.Exchange(ctx, "good-code", verifier, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if identity.EmailVerified {
+			t.Error("expected the email to be unverified")
+		}
+	})
+
+	t.Run("should fail without the right code verifier", func(t *testing.T) {
+		stub := newStubProvider(t)
+		provider, _, nonce := login(t, stub)
+
+		if _, err := provider.Exchange(ctx, "good-code", "wrong-verifier", nonce); err == nil {
+			t.Error("expected the exchange to fail")
+		}
+	})
+
+	t.Run("should reject a token for another nonce", func(t *testing.T) {
+		stub := newStubProvider(t)
+		provider, verifier, _ := login(t, stub)
+
+		if _, err := provider.Exchange(ctx, "good-code", verifier, "nonce-2"); err == nil {
+			t.Error("expected the nonce to be rejected")
+		}
+	})
+
+	t.Run("should reject a token for another client", func(t *testing.T) {
+		stub := newStubProvider(t)
+		stub.claims = jwt.MapClaims{"aud": "someone-else"}
+		provider, verifier, nonce := login(t, stub)
+
+		if _, err := provider.Exchange(ctx, "good-code", verifier, nonce); err == nil {
+			t.Error("expected the audience to be rejected")
+		}
+	})
+}
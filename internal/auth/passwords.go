@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrPasswordBreached = errors.New("password is too common, it appears in known data breaches")
+
+//go:embed breached_passwords.txt
+var breachedPasswords string
+
+// PasswordPolicy decides which new passwords are acceptable, logins with existing passwords never go through it
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	breached  map[string]struct{}
+}
+
+// NewPasswordPolicy uses the built-in list of breached passwords plus the one in breachedFile, if given
+func NewPasswordPolicy(minLength, maxLength int, breachedFile string) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{
+		MinLength: minLength,
+		MaxLength: maxLength,
+		breached:  map[string]struct{}{},
+	}
+
+	if err := p.addBreached(strings.NewReader(breachedPasswords)); err != nil {
+		return nil, err
+	}
+
+	if breachedFile != "" {
+		f, err := os.Open(breachedFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		if err := p.addBreached(f); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func (p *PasswordPolicy) addBreached(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.breached[strings.ToLower(line)] = struct{}{}
+	}
+
+	return scanner.Err()
+}
+
+// Check returns an error which can be shown to the user as is
+func (p *PasswordPolicy) Check(password string) error {
+	//length in characters, not bytes, a password of emojis shouldn't count 4 times
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf("password must be at most %d characters long", p.MaxLength)
+	}
+
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		return ErrPasswordBreached
+	}
+
+	return nil
+}
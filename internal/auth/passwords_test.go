@@ -0,0 +1,28 @@
+package auth
+
+import "testing"
+
+func TestPasswordPolicy(t *testing.T) {
+	policy, err := NewPasswordPolicy(8, 64, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		ok       bool
+	}{
+		{"short", false},
+		{"Password123", false},
+		{"correct horse battery staple", true},
+		{"ääääääää", true},
+		{string(make([]byte, 65)), false},
+	}
+
+	for _, tt := range tests {
+		err := policy.Check(tt.password)
+		if (err == nil) != tt.ok {
+			t.Errorf("password %q: expected ok=%v, got %v", tt.password, tt.ok, err)
+		}
+	}
+}
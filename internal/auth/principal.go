@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrNoCredentials means the request doesn't carry the credential an extractor looks for, the chain
+	// moves on to the next one
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is a credential which is present but wrong, the chain stops there
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Method is how a principal proved who it is
+type Method string
+
+const (
+	MethodBearer        Method = "bearer"
+	MethodAPIKey        Method = "api_key"
+	MethodSessionCookie Method = "session_cookie"
+	MethodBasic         Method = "basic"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID int64
+	Method Method
+	// Scopes is nil when the principal may do everything the user may, API keys always carry their own list
+	Scopes []string
+	// SessionID, TokenID and the times are only set for access tokens (bearer and cookie)
+	SessionID string
+	TokenID   string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// HasScope reports if the principal was granted scope, see HasScope
+func (p *Principal) HasScope(scope string) bool {
+	return HasScope(p.Scopes, scope)
+}
+
+// HasSession is true for principals logged in with an access token, as opposed to a key or a password
+func (p *Principal) HasSession() bool {
+	return p.SessionID != ""
+}
+
+// Extractor finds one kind of credential in a request. It returns ErrNoCredentials when the request
+// doesn't carry that kind, anything else ends the chain
+type Extractor interface {
+	Extract(r *http.Request) (*Principal, error)
+}
+
+// Chain tries its extractors in order, the first one which finds its credential decides
+type Chain []Extractor
+
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, e := range c {
+		p, err := e.Extract(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return p, err
+	}
+
+	return nil, ErrNoCredentials
+}
+
+// BearerExtractor reads an access token from "Authorization: Bearer <jwt>"
+type BearerExtractor struct {
+	Authenticator Authenticator
+}
+
+func (e *BearerExtractor) Extract(r *http.Request) (*Principal, error) {
+	token, ok := authorization(r, "Bearer")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	return accessTokenPrincipal(e.Authenticator, token, MethodBearer)
+}
+
+// CookieExtractor reads an access token from a cookie, for browsers which can't keep the token in JS.
+// The cookie has to be SameSite so other sites can't make requests with it
+type CookieExtractor struct {
+	Authenticator Authenticator
+	Name          string
+}
+
+func (e *CookieExtractor) Extract(r *http.Request) (*Principal, error) {
+	cookie, err := r.Cookie(e.Name)
+	if err != nil || cookie.Value == "" {
+		return nil, ErrNoCredentials
+	}
+
+	return accessTokenPrincipal(e.Authenticator, cookie.Value, MethodSessionCookie)
+}
+
+// APIKeyExtractor reads a personal API key from "Authorization: ApiKey <key>" or the X-API-Key header.
+// Lookup returns ErrInvalidCredentials for unknown keys
+type APIKeyExtractor struct {
+	Lookup func(r *http.Request, key string) (userID int64, scopes []string, err error)
+}
+
+func (e *APIKeyExtractor) Extract(r *http.Request) (*Principal, error) {
+	key, ok := authorization(r, "ApiKey")
+	if !ok {
+		key = r.Header.Get("X-API-Key")
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	userID, scopes, err := e.Lookup(r, key)
+	if err != nil {
+		return nil, err
+	}
+	//a key without scopes may do nothing, nil would mean everything
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	return &Principal{UserID: userID, Method: MethodAPIKey, Scopes: scopes}, nil
+}
+
+// BasicExtractor checks "Authorization: Basic" email and password against the user accounts.
+// Lookup returns ErrInvalidCredentials when they don't match
+type BasicExtractor struct {
+	Lookup func(r *http.Request, email, password string) (userID int64, err error)
+}
+
+func (e *BasicExtractor) Extract(r *http.Request) (*Principal, error) {
+	email, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	userID, err := e.Lookup(r, email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{UserID: userID, Method: MethodBasic}, nil
+}
+
+// authorization returns the credentials of an Authorization header with the given scheme
+func authorization(r *http.Request, scheme string) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(scheme)+1 || header[:len(scheme)] != scheme || header[len(scheme)] != ' ' {
+		return "", false
+	}
+
+	return header[len(scheme)+1:], true
+}
+
+func accessTokenPrincipal(authenticator Authenticator, token string, method Method) (*Principal, error) {
+	jwtToken, err := authenticator.ValidateToken(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+
+	claims, _ := jwtToken.Claims.(jwt.MapClaims)
+
+	//mfa challenge and oidc state tokens are signed by the same authenticator but are not access tokens
+	if typ, _ := claims["typ"].(string); typ != "" {
+		return nil, fmt.Errorf("%w: token of type %q is not an access token", ErrInvalidCredentials, typ)
+	}
+
+	userID, err := UserIDFromClaims(claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+
+	p := &Principal{UserID: userID, Method: method}
+	p.SessionID, _ = claims["sid"].(string)
+	p.TokenID, _ = claims["jti"].(string)
+	//GetIssuedAt would cut off the microseconds we issue iat with
+	if iat, ok := claims["iat"].(float64); ok {
+		p.IssuedAt = time.UnixMicro(int64(math.Round(iat * 1e6)))
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		p.ExpiresAt = exp.Time
+	}
+
+	return p, nil
+}
+
+// UserIDFromClaims reads the user ID from sub, which we issue as a number but other issuers send as a string
+func UserIDFromClaims(claims jwt.MapClaims) (int64, error) {
+	switch sub := claims["sub"].(type) {
+	case float64:
+		if sub != math.Trunc(sub) || sub < 1 || sub > math.MaxInt64 {
+			return 0, fmt.Errorf("invalid sub %v", sub)
+		}
+		return int64(sub), nil
+	case json.Number:
+		return strconv.ParseInt(sub.String(), 10, 64)
+	case string:
+		return strconv.ParseInt(sub, 10, 64)
+	default:
+		return 0, fmt.Errorf("invalid sub %v", sub)
+	}
+}
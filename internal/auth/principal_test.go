@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestChain(t *testing.T) {
+	authenticator := &TestAuthenticator{}
+	token, err := authenticator.GenerateToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := Chain{
+		&BearerExtractor{Authenticator: authenticator},
+		&CookieExtractor{Authenticator: authenticator, Name: "session"},
+		&APIKeyExtractor{Lookup: func(r *http.Request, key string) (int64, []string, error) {
+			if key != "gsk_valid" {
+				return 0, nil, ErrInvalidCredentials
+			}
+			return 7, nil, nil
+		}},
+	}
+
+	t.Run("should find a bearer token", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		p, err := chain.Authenticate(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.UserID != 1 || p.Method != MethodBearer || p.SessionID != "test-session" || p.TokenID != "test-token" {
+			t.Errorf("unexpected principal %+v", p)
+		}
+		if !p.HasScope(ScopePostsWrite) {
+			t.Error("a session should have every scope")
+		}
+	})
+
+	t.Run("should fall through to the cookie", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: "session", Value: token})
+
+		p, err := chain.Authenticate(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Method != MethodSessionCookie {
+			t.Errorf("expected %s, got %s", MethodSessionCookie, p.Method)
+		}
+	})
+
+	t.Run("should give api keys an empty scope list", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-API-Key", "gsk_valid")
+
+		p, err := chain.Authenticate(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.UserID != 7 || p.Scopes == nil || p.HasScope(ScopePostsRead) || p.HasSession() {
+			t.Errorf("unexpected principal %+v", p)
+		}
+	})
+
+	t.Run("should stop at an invalid credential", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer not-a-jwt")
+		r.AddCookie(&http.Cookie{Name: "session", Value: token})
+
+		_, err := chain.Authenticate(r)
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("expected ErrInvalidCredentials, got %v", err)
+		}
+	})
+
+	t.Run("should report missing credentials", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Basic Zm9vOmJhcg==")
+
+		_, err := chain.Authenticate(r)
+		if !errors.Is(err, ErrNoCredentials) {
+			t.Errorf("expected ErrNoCredentials, got %v", err)
+		}
+	})
+}
+
+func TestUserIDFromClaims(t *testing.T) {
+	tests := []struct {
+		sub any
+		id  int64
+		ok  bool
+	}{
+		{float64(42), 42, true},
+		{"42", 42, true},
+		{float64(1.5), 0, false},
+		{float64(-3), 0, false},
+		{nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		id, err := UserIDFromClaims(jwt.MapClaims{"sub": tt.sub})
+		if (err == nil) != tt.ok || id != tt.id {
+			t.Errorf("sub %v: expected %d ok=%v, got %d %v", tt.sub, tt.id, tt.ok, id, err)
+		}
+	}
+}
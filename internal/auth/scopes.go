@@ -0,0 +1,28 @@
+package auth
+
+// scopes an API key can be limited to, user sessions (JWTs) are never limited
+const (
+	ScopePostsRead  = "posts:read"
+	ScopePostsWrite = "posts:write"
+	ScopeFeedRead   = "feed:read"
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+)
+
+// APIKeyPrefix makes our keys easy to spot, e.g. by secret scanners
+const APIKeyPrefix = "gsk_"
+
+// HasScope reports if scopes grants scope. A nil slice is a user session which may do everything
+func HasScope(scopes []string, scope string) bool {
+	if scopes == nil {
+		return true
+	}
+
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
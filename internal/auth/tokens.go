@@ -0,0 +1,23 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+var (
+	ErrMissingSession = errors.New("token is not bound to a session")
+	ErrTokenRevoked   = errors.New("token has been revoked")
+)
+
+// NewOpaqueToken returns a random url safe string, used for refresh tokens which are not JWTs
+// so they mean nothing without a lookup on our side
+func NewOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
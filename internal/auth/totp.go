@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 defaults, these are the only values authenticator apps reliably support
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	//codes of the step before and after are accepted too, phone clocks drift
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random 160 bit secret, base32 encoded like authenticator apps expect it
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPURI builds the otpauth:// URI which is rendered as a QR code for enrollment
+func TOTPURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// ValidateTOTP checks code against the secret at time t. It returns the matched time step so the
+// caller can refuse the same code twice, ok is false if no step in the allowed skew matches
+func ValidateTOTP(secret, code string, t time.Time) (step int64, ok bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := t.Unix() / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		candidate := current + int64(i)
+		if subtle.ConstantTimeCompare([]byte(hotp(key, candidate)), []byte(code)) == 1 {
+			return candidate, true
+		}
+	}
+
+	return 0, false
+}
+
+// hotp is the RFC 4226 algorithm, TOTP is HOTP with the time step as counter
+func hotp(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// NewRecoveryCodes returns n one time codes like "7kq2-m9xd-4hpf", they are shown once and stored hashed
+func NewRecoveryCodes(n int) ([]string, error) {
+	const alphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+
+	codes := make([]string, n)
+	for i := range codes {
+		b, err := randomChars(alphabet, 12)
+		if err != nil {
+			return nil, err
+		}
+
+		var sb strings.Builder
+		for j, c := range b {
+			if j > 0 && j%4 == 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteByte(c)
+		}
+		codes[i] = sb.String()
+	}
+
+	return codes, nil
+}
+
+// randomChars picks n characters of alphabet uniformly. 256 isn't a multiple of the alphabet length,
+// so random bytes from the incomplete last round would favour its first characters and are skipped
+func randomChars(alphabet string, n int) ([]byte, error) {
+	limit := 256 - 256%len(alphabet)
+
+	chars := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(chars) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+
+		for _, c := range buf {
+			if int(c) < limit && len(chars) < n {
+				chars = append(chars, alphabet[int(c)%len(alphabet)])
+			}
+		}
+	}
+
+	return chars, nil
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"encoding/base32"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// test vectors from RFC 6238 appendix B (SHA1), truncated to our 6 digits
+func TestValidateTOTP(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(secret, tt.code, time.Unix(tt.unix, 0))
+		if !ok {
+			t.Errorf("code %s at %d was rejected", tt.code, tt.unix)
+		}
+		if step != tt.unix/totpPeriod {
+			t.Errorf("expected step %d, got %d", tt.unix/totpPeriod, step)
+		}
+	}
+
+	if _, ok := ValidateTOTP(secret, "287082", time.Unix(59+totpPeriod*3, 0)); ok {
+		t.Error("expected an old code to be rejected")
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	format := regexp.MustCompile(`^[2-9a-hjkmnp-z]{4}-[2-9a-hjkmnp-z]{4}-[2-9a-hjkmnp-z]{4}$`)
+	seen := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("unexpected code %q", code)
+		}
+		if seen[code] {
+			t.Errorf("duplicate code %q", code)
+		}
+		seen[code] = true
+	}
+}
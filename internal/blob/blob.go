@@ -0,0 +1,21 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Store keeps the bytes of uploads, the database only knows their keys. Keys are slash separated
+// like "media/<uuid>", so an S3 compatible bucket can be plugged in as well as the local filesystem
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	//deleting a blob that doesn't exist is not an error, the garbage collector might come by twice
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,50 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Put(ctx, "media/a", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := s.Open(ctx, "media/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Errorf("expected hello, got %q", b)
+	}
+
+	if err := s.Delete(ctx, "media/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "media/a"); err != nil {
+		t.Errorf("deleting twice: %v", err)
+	}
+	if _, err := s.Open(ctx, "media/a"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	for _, key := range []string{"../a", "/etc/passwd", "media/../../a", ""} {
+		if err := s.Put(ctx, key, strings.NewReader("x")); err != ErrInvalidKey {
+			t.Errorf("key %q: expected ErrInvalidKey, got %v", key, err)
+		}
+	}
+}
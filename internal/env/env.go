@@ -3,6 +3,7 @@ package env
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 func GetString(key, fallback string) string {
@@ -37,3 +38,14 @@ func GetBool(key string, fallback bool) bool {
 	}
 	return boolVal
 }
+
+// GetList splits a comma separated value, entries are trimmed and empty ones dropped
+func GetList(key, fallback string) []string {
+	var list []string
+	for _, item := range strings.Split(GetString(key, fallback), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -3,9 +3,15 @@ package mailer
 import "embed"
 
 const (
-	FromName            = "GopherSocial"
-	maxRetries          = 3
-	UserWelcomeTemplate = "user_invitation.tmpl"
+	FromName              = "GopherSocial"
+	maxRetries            = 3
+	UserWelcomeTemplate   = "user_invitation.tmpl"
+	AccountUnlockTemplate = "account_unlock.tmpl"
+	PasswordResetTemplate = "password_reset.tmpl"
+
+	EmailChangeConfirmTemplate = "email_change_confirm.tmpl"
+	EmailChangeNoticeTemplate  = "email_change_notice.tmpl"
+	MagicLinkTemplate          = "magic_link.tmpl"
 )
 
 /*
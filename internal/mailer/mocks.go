@@ -0,0 +1,50 @@
+package mailer
+
+import "sync"
+
+// MockClient doesn't send anything, it only remembers which templates were sent to whom and with what
+type MockClient struct {
+	mu   sync.Mutex
+	Sent []MockEmail
+}
+
+type MockEmail struct {
+	Template string
+	Email    string
+	Data     any
+}
+
+func (m *MockClient) Send(templateFile, username, email string, data any, isSandbox bool) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Sent = append(m.Sent, MockEmail{Template: templateFile, Email: email, Data: data})
+	return 200, nil
+}
+
+// Last returns the last email sent with templateFile, the zero MockEmail if there is none
+func (m *MockClient) Last(templateFile string) MockEmail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.Sent) - 1; i >= 0; i-- {
+		if m.Sent[i].Template == templateFile {
+			return m.Sent[i]
+		}
+	}
+	return MockEmail{}
+}
+
+// Count returns how many emails were sent with templateFile
+func (m *MockClient) Count(templateFile string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, e := range m.Sent {
+		if e.Template == templateFile {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// last_used_at is only written when it is older than this, so keys don't cost a write per request
+const apiKeyTouchInterval = time.Minute * 5
+
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  string     `json:"created_at"`
+}
+
+type APIKeyStore struct {
+	db *sql.DB
+}
+
+// Create stores the hash of the plaintext key, it is never shown again after this
+func (s *APIKeyStore) Create(ctx context.Context, key *APIKey, plainKey string) error {
+	query := `
+	INSERT INTO api_keys (user_id, name, prefix, key, scopes, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		hashToken(plainKey),
+		pq.Array(key.Scopes),
+		key.ExpiresAt,
+	).Scan(&key.ID, &key.CreatedAt)
+}
+
+func (s *APIKeyStore) GetByUserID(ctx context.Context, userID int64) ([]APIKey, error) {
+	query := `
+	SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at
+	FROM api_keys
+	WHERE user_id = $1 AND revoked_at IS NULL
+	ORDER BY created_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		err := rows.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &k.ExpiresAt, &k.LastUsedAt, &k.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+// GetByKey looks up a usable (not revoked, not expired) key by its plaintext value
+func (s *APIKeyStore) GetByKey(ctx context.Context, plainKey string) (*APIKey, error) {
+	query := `
+	SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at
+	FROM api_keys
+	WHERE key = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	k := &APIKey{}
+	err := s.db.QueryRowContext(ctx, query, hashToken(plainKey)).Scan(
+		&k.ID, &k.UserID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &k.ExpiresAt, &k.LastUsedAt, &k.CreatedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if k.LastUsedAt == nil || time.Since(*k.LastUsedAt) > apiKeyTouchInterval {
+		_, err := s.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = NOW() WHERE id = $1`, k.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return k, nil
+}
+
+// Revoke only revokes keys owned by userID, somebody else's key id is ErrNotFound
+func (s *APIKeyStore) Revoke(ctx context.Context, keyID, userID int64) error {
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, keyID, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// RevokeAllForUser revokes every key of the user, for logging out everywhere
+func (s *APIKeyStore) RevokeAllForUser(ctx context.Context, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return revokeAllAPIKeys(ctx, s.db, userID)
+}
+
+// revokeAllAPIKeys is RevokeAllForUser for a transaction, see UserStore.ResetPassword
+func revokeAllAPIKeys(ctx context.Context, db execer, userID int64) error {
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+
+	_, err := db.ExecContext(ctx, query, userID)
+	return err
+}
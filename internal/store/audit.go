@@ -0,0 +1,229 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// actions written to audit_events
+const (
+	AuditUserRegistered       = "user.registered"
+	AuditUserActivated        = "user.activated"
+	AuditUserDeleted          = "user.deleted"
+	AuditLogin                = "auth.login"
+	AuditLoginFailed          = "auth.login_failed"
+	AuditMagicLinkRequested   = "auth.magic_link_requested"
+	AuditAccountLocked        = "auth.account_locked"
+	AuditAccountUnlocked      = "auth.account_unlocked"
+	AuditRefreshTokenReused   = "auth.refresh_token_reused"
+	AuditLogout               = "auth.logout"
+	AuditLogoutAll            = "auth.logout_all"
+	AuditSessionRevoked       = "auth.session_revoked"
+	AuditPasswordResetRequest = "password.reset_requested"
+	AuditPasswordReset        = "password.reset"
+	AuditEmailChangeRequested = "email.change_requested"
+	AuditEmailChanged         = "email.changed"
+	AuditMFAEnabled           = "mfa.enabled"
+	AuditMFADisabled          = "mfa.disabled"
+	AuditAPIKeyCreated        = "api_key.created"
+	AuditAPIKeyRevoked        = "api_key.revoked"
+	AuditPostModerated        = "post.moderated"
+	AuditCommentModerated     = "comment.moderated"
+	AuditContentRestored      = "content.restored"
+	AuditRoleCreated          = "role.created"
+	AuditRoleUpdated          = "role.updated"
+	AuditRoleAssigned         = "role.assigned"
+	AuditPermissionDenied     = "authz.permission_denied"
+)
+
+// AuditEvent is one entry of the security audit log, ActorID and TargetID are 0 when there is none
+type AuditEvent struct {
+	ID         int64          `json:"id"`
+	ActorID    int64          `json:"actor_id,omitempty"`
+	Action     string         `json:"action"`
+	TargetType string         `json:"target_type,omitempty"`
+	TargetID   int64          `json:"target_id,omitempty"`
+	IP         string         `json:"ip"`
+	RequestID  string         `json:"request_id"`
+	Metadata   map[string]any `json:"metadata"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// AuditQuery filters the audit log, empty fields match everything
+type AuditQuery struct {
+	Limit      int       `json:"limit" validate:"gte=1,lte=100"`
+	Offset     int       `json:"offset" validate:"gte=0"`
+	ActorID    int64     `json:"actor_id" validate:"gte=0"`
+	Action     string    `json:"action" validate:"max=100"`
+	TargetType string    `json:"target_type" validate:"max=50"`
+	TargetID   int64     `json:"target_id" validate:"gte=0"`
+	Since      time.Time `json:"since"`
+	Until      time.Time `json:"until"`
+}
+
+// Parse reads the filters from the URL like PaginatedFeedQuery.Parse, since and until are RFC 3339
+func (q AuditQuery) Parse(r *http.Request) (AuditQuery, error) {
+	qs := r.URL.Query()
+
+	var err error
+	if limit := qs.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil {
+			return q, err
+		}
+	}
+	if offset := qs.Get("offset"); offset != "" {
+		if q.Offset, err = strconv.Atoi(offset); err != nil {
+			return q, err
+		}
+	}
+	if actorID := qs.Get("actor_id"); actorID != "" {
+		if q.ActorID, err = strconv.ParseInt(actorID, 10, 64); err != nil {
+			return q, err
+		}
+	}
+	if targetID := qs.Get("target_id"); targetID != "" {
+		if q.TargetID, err = strconv.ParseInt(targetID, 10, 64); err != nil {
+			return q, err
+		}
+	}
+	if since := qs.Get("since"); since != "" {
+		if q.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return q, err
+		}
+	}
+	if until := qs.Get("until"); until != "" {
+		if q.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return q, err
+		}
+	}
+
+	q.Action = qs.Get("action")
+	q.TargetType = qs.Get("target_type")
+
+	return q, nil
+}
+
+type AuditStore struct {
+	db *sql.DB
+}
+
+// Create writes a batch of events in one transaction, the API buffers them and flushes every so often
+func (s *AuditStore) Create(ctx context.Context, events []*AuditEvent) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+		INSERT INTO audit_events (actor_id, action, target_type, target_id, ip, request_id, metadata, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		stmt, err := tx.PrepareContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, e := range events {
+			metadata, err := json.Marshal(e.Metadata)
+			if err != nil {
+				return err
+			}
+			if e.Metadata == nil {
+				metadata = []byte("{}")
+			}
+
+			_, err = stmt.ExecContext(
+				ctx,
+				nullID(e.ActorID),
+				e.Action,
+				sql.NullString{String: e.TargetType, Valid: e.TargetType != ""},
+				nullID(e.TargetID),
+				e.IP,
+				e.RequestID,
+				metadata,
+				e.CreatedAt,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// List returns the newest events first
+func (s *AuditStore) List(ctx context.Context, q AuditQuery) ([]AuditEvent, error) {
+	query := `
+	SELECT id, actor_id, action, target_type, target_id, ip, request_id, metadata, created_at
+	FROM audit_events
+	WHERE
+		($1::bigint = 0 OR actor_id = $1) AND
+		($2 = '' OR action = $2) AND
+		($3 = '' OR target_type = $3) AND
+		($4::bigint = 0 OR target_id = $4) AND
+		($5::timestamptz IS NULL OR created_at >= $5) AND
+		($6::timestamptz IS NULL OR created_at < $6)
+	ORDER BY id DESC
+	LIMIT $7 OFFSET $8
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		query,
+		q.ActorID,
+		q.Action,
+		q.TargetType,
+		q.TargetID,
+		nullTime(q.Since),
+		nullTime(q.Until),
+		q.Limit,
+		q.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var (
+			e          AuditEvent
+			actorID    sql.NullInt64
+			targetType sql.NullString
+			targetID   sql.NullInt64
+			metadata   []byte
+		)
+		err := rows.Scan(&e.ID, &actorID, &e.Action, &targetType, &targetID, &e.IP, &e.RequestID, &metadata, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		e.ActorID = actorID.Int64
+		e.TargetType = targetType.String
+		e.TargetID = targetID.Int64
+		if err := json.Unmarshal(metadata, &e.Metadata); err != nil {
+			return nil, err
+		}
+
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
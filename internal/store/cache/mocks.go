@@ -3,6 +3,8 @@ package cache
 import (
 	"context"
 	"social/internal/store"
+	"sync"
+	"time"
 	//ex 63 spies package
 )
 
@@ -11,18 +13,71 @@ import (
 
 func NewMockStore() Storage {
 	return Storage{
-		Users: &MockUserStore{},
+		Users:  &MockUserStore{},
+		Roles:  &MockRoleStore{},
+		Tokens: &MockTokenStore{},
 	}
 }
 
+// MockUserStore never has a user cached, it only remembers which ones were deleted
 type MockUserStore struct {
 	//mock.Mock
+	mu      sync.Mutex
+	Deleted []int64
 }
 
-func (m MockUserStore) Get(ctx context.Context, id int64) (*store.User, error) {
+func (m *MockUserStore) Get(ctx context.Context, id int64) (*store.User, error) {
 	return nil, nil
 }
 
-func (m MockUserStore) Set(ctx context.Context, user *store.User) error {
+func (m *MockUserStore) Set(ctx context.Context, user *store.User) error {
+	return nil
+}
+
+func (m *MockUserStore) Delete(ctx context.Context, userIDs ...int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Deleted = append(m.Deleted, userIDs...)
+	return nil
+}
+
+type MockTokenStore struct{}
+
+func (m MockTokenStore) Revoke(ctx context.Context, jti string, exp time.Time) error {
+	return nil
+}
+
+func (m MockTokenStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	return false, nil
+}
+
+func (m MockTokenStore) RevokeIssuedBefore(ctx context.Context, userID int64, t time.Time, ttl time.Duration) error {
+	return nil
+}
+
+func (m MockTokenStore) IssuedBefore(ctx context.Context, userID int64) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (m MockTokenStore) RevokeFamily(ctx context.Context, familyID string, ttl time.Duration) error {
+	return nil
+}
+
+func (m MockTokenStore) IsFamilyRevoked(ctx context.Context, familyID string) (bool, error) {
+	return false, nil
+}
+
+type MockRoleStore struct{}
+
+func (m MockRoleStore) GetPermissions(ctx context.Context, roleID int64) ([]string, error) {
+	return nil, nil
+}
+
+func (m MockRoleStore) SetPermissions(ctx context.Context, roleID int64, permissions []string) error {
+	return nil
+}
+
+func (m MockRoleStore) DeletePermissions(ctx context.Context, roleID int64) error {
 	return nil
 }
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RoleStore caches the permission set of every role, they are needed on each authorized request
+type RoleStore struct {
+	rdb *redis.Client
+}
+
+const RolePermissionsExpTime = time.Minute * 5
+
+// GetPermissions returns nil without an error when the role isn't cached
+func (s *RoleStore) GetPermissions(ctx context.Context, roleID int64) ([]string, error) {
+	cacheKey := fmt.Sprintf("role-permissions-%v", roleID)
+
+	data, err := s.rdb.Get(ctx, cacheKey).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	permissions := []string{}
+	if err := json.Unmarshal([]byte(data), &permissions); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+func (s *RoleStore) SetPermissions(ctx context.Context, roleID int64, permissions []string) error {
+	cacheKey := fmt.Sprintf("role-permissions-%v", roleID)
+
+	json, err := json.Marshal(permissions)
+	if err != nil {
+		return err
+	}
+
+	return s.rdb.SetEX(ctx, cacheKey, json, RolePermissionsExpTime).Err()
+}
+
+// DeletePermissions drops the cached set after the permissions of the role changed
+func (s *RoleStore) DeletePermissions(ctx context.Context, roleID int64) error {
+	cacheKey := fmt.Sprintf("role-permissions-%v", roleID)
+
+	return s.rdb.Del(ctx, cacheKey).Err()
+}
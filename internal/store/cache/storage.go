@@ -3,6 +3,7 @@ package cache
 import (
 	"context"
 	"social/internal/store"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 )
@@ -11,11 +12,27 @@ type Storage struct {
 	Users interface {
 		Get(context.Context, int64) (*store.User, error)
 		Set(context.Context, *store.User) error
+		Delete(context.Context, ...int64) error
+	}
+	Roles interface {
+		GetPermissions(context.Context, int64) ([]string, error)
+		SetPermissions(ctx context.Context, roleID int64, permissions []string) error
+		DeletePermissions(context.Context, int64) error
+	}
+	Tokens interface {
+		Revoke(ctx context.Context, jti string, exp time.Time) error
+		IsRevoked(ctx context.Context, jti string) (bool, error)
+		RevokeIssuedBefore(ctx context.Context, userID int64, t time.Time, ttl time.Duration) error
+		IssuedBefore(context.Context, int64) (time.Time, error)
+		RevokeFamily(ctx context.Context, familyID string, ttl time.Duration) error
+		IsFamilyRevoked(ctx context.Context, familyID string) (bool, error)
 	}
 }
 
 func NewRedisStorage(rbd *redis.Client) Storage {
 	return Storage{
-		Users: &UserStore{rbd},
+		Users:  &UserStore{rbd},
+		Roles:  &RoleStore{rbd},
+		Tokens: &TokenStore{rbd},
 	}
 }
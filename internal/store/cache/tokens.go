@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TokenStore is the access token denylist, every key expires together with the tokens it denies
+type TokenStore struct {
+	rdb *redis.Client
+}
+
+// Revoke denies the access token with this jti until exp
+func (s *TokenStore) Revoke(ctx context.Context, jti string, exp time.Time) error {
+	ttl := time.Until(exp)
+	if ttl <= 0 {
+		return nil
+	}
+
+	cacheKey := fmt.Sprintf("revoked-jti-%v", jti)
+
+	return s.rdb.SetEX(ctx, cacheKey, "1", ttl).Err()
+}
+
+func (s *TokenStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	cacheKey := fmt.Sprintf("revoked-jti-%v", jti)
+
+	n, err := s.rdb.Exists(ctx, cacheKey).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// RevokeIssuedBefore denies every access token of the user issued before t, ttl should be the access token
+// lifetime because after that no token from before t can be valid anymore
+func (s *TokenStore) RevokeIssuedBefore(ctx context.Context, userID int64, t time.Time, ttl time.Duration) error {
+	cacheKey := fmt.Sprintf("tokens-before-%v", userID)
+
+	return s.rdb.SetEX(ctx, cacheKey, t.UnixMicro(), ttl).Err()
+}
+
+// IssuedBefore returns the watermark set by RevokeIssuedBefore, the zero time if there is none
+func (s *TokenStore) IssuedBefore(ctx context.Context, userID int64) (time.Time, error) {
+	cacheKey := fmt.Sprintf("tokens-before-%v", userID)
+
+	data, err := s.rdb.Get(ctx, cacheKey).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+
+	micro, err := strconv.ParseInt(data, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.UnixMicro(micro), nil
+}
+
+// RevokeFamily denies every access token with this sid, ttl should be the access token lifetime like for
+// RevokeIssuedBefore
+func (s *TokenStore) RevokeFamily(ctx context.Context, familyID string, ttl time.Duration) error {
+	cacheKey := fmt.Sprintf("revoked-sid-%v", familyID)
+
+	return s.rdb.SetEX(ctx, cacheKey, "1", ttl).Err()
+}
+
+func (s *TokenStore) IsFamilyRevoked(ctx context.Context, familyID string) (bool, error) {
+	cacheKey := fmt.Sprintf("revoked-sid-%v", familyID)
+
+	n, err := s.rdb.Exists(ctx, cacheKey).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
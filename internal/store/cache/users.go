@@ -58,3 +58,17 @@ func (s *UserStore) Set(ctx context.Context, user *store.User) error {
 	return nil
 
 }
+
+// Delete drops the cached users, used when something the cached copies carry has changed
+func (s *UserStore) Delete(ctx context.Context, userIDs ...int64) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	cacheKeys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		cacheKeys[i] = fmt.Sprintf("user-%v", userID)
+	}
+
+	return s.rdb.Del(ctx, cacheKeys...).Err()
+}
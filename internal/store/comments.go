@@ -3,58 +3,320 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
 )
 
+// DeletedCommentContent stands in for comments that were deleted while they had replies
+const DeletedCommentContent = "[deleted]"
+
 type Comment struct {
 	ID        int64  `json:"id"`
 	PostID    int64  `json:"post_id"`
 	UserID    int64  `json:"user_id"`
 	Content   string `json:"content"`
 	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
 	User      User   `json:"user"`
+	//nil for comments on the post itself
+	ParentID   *int64 `json:"parent_id"`
+	ReplyCount int    `json:"reply_count"`
+	//deleted comments (or those of deleted users) with replies stay in the thread as a placeholder without content or author
+	Deleted bool `json:"deleted"`
+	//only filled by GetThread, up to the requested depth
+	Replies *CommentPage `json:"replies,omitempty"`
+}
+
+// CommentThreadQuery fetches the replies below a comment, Depth levels deep. The cursor pages
+// through the direct replies, every level below starts with its newest replies
+type CommentThreadQuery struct {
+	CursorQuery
+	Depth int `json:"depth" validate:"gte=1,lte=5"`
+}
+
+func (q CommentThreadQuery) Parse(r *http.Request) (CommentThreadQuery, error) {
+	if depth := r.URL.Query().Get("depth"); depth != "" {
+		d, err := strconv.Atoi(depth)
+		if err != nil {
+			return q, err
+		}
+		q.Depth = d
+	}
+
+	var err error
+	q.CursorQuery, err = q.CursorQuery.Parse(r)
+	return q, err
+}
+
+// CommentPage is one page of comments, NextCursor is empty on the last one
+type CommentPage struct {
+	Comments   []Comment `json:"comments"`
+	NextCursor string    `json:"next_cursor,omitempty"`
 }
 
 type CommentStore struct {
 	db *sql.DB
 }
 
+// commentDeletedQuery is true when comment c or its author is, users are joined with LEFT JOIN because the
+// comments of purged users stay behind as long as they have replies
+func commentDeletedQuery(c, users string) string {
+	return `(` + c + `.deleted_at IS NOT NULL OR ` + users + `.deleted_at IS NOT NULL OR ` + users + `.id IS NULL)`
+}
+
+// commentVisibleQuery is true for comments still there and for placeholders of deleted ones that keep
+// a reply in the thread. A placeholder goes once every reply below it, however deep, is deleted too
+func commentVisibleQuery(c, users string) string {
+	return `(NOT ` + commentDeletedQuery(c, users) + ` OR EXISTS (
+		WITH RECURSIVE below AS (
+			SELECT d.id, d.user_id, d.deleted_at FROM comments d WHERE d.parent_id = ` + c + `.id
+			UNION ALL
+			SELECT d.id, d.user_id, d.deleted_at FROM comments d JOIN below ON d.parent_id = below.id
+		)
+		SELECT 1 FROM below
+		JOIN users du ON du.id = below.user_id
+		WHERE below.deleted_at IS NULL AND du.deleted_at IS NULL
+	))`
+}
+
+var (
+	commentDeleted = commentDeletedQuery("c", "users")
+	commentVisible = commentVisibleQuery("c", "users")
+)
+
+// columns read by scanComment, the reply count only counts the replies GetThread would return
+var commentColumns = `
+	c.id, c.post_id, c.user_id, c.parent_id, c.content, c.created_at, c.updated_at, ` + commentDeleted + `,
+	(
+		SELECT COUNT(*) FROM comments r
+		LEFT JOIN users ru ON ru.id = r.user_id
+		WHERE r.parent_id = c.id AND ` + commentVisibleQuery("r", "ru") + `
+	),
+	COALESCE(users.username, '')
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanComment(row rowScanner) (Comment, error) {
+	var c Comment
+	var parentID sql.NullInt64
+	err := row.Scan(
+		&c.ID,
+		&c.PostID,
+		&c.UserID,
+		&parentID,
+		&c.Content,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+		&c.Deleted,
+		&c.ReplyCount,
+		&c.User.Username,
+	)
+	if err != nil {
+		return c, err
+	}
+
+	if parentID.Valid {
+		c.ParentID = &parentID.Int64
+	}
+
+	c.User.ID = c.UserID
+	if c.Deleted {
+		c.UserID = 0
+		c.User = User{}
+		c.Content = DeletedCommentContent
+	}
+
+	return c, nil
+}
+
+func scanComments(rows *sql.Rows) ([]Comment, error) {
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+
+	return comments, rows.Err()
+}
+
 // exercise 27: This will fetch comments using postID by below SQL commands
-func (s *CommentStore) GetByPostID(ctx context.Context, postID int64) ([]Comment, error) {
+// only comments on the post itself, replies come with GetThread. ids only grow, so ordering by id is
+// the same as by created_at and gives us a stable cursor
+func (s *CommentStore) GetByPostID(ctx context.Context, postID int64, q CursorQuery) (*CommentPage, error) {
 	query := `
-		SELECT c.id, c.post_id, c.user_id, c.content, c.created_at, users.username, users.id  FROM comments c
-		JOIN users on users.id = c.user_id
-		WHERE c.post_id = $1
-		ORDER BY c.created_at DESC;
+		SELECT ` + commentColumns + ` FROM comments c
+		LEFT JOIN users on users.id = c.user_id
+		WHERE c.post_id = $1 AND c.parent_id IS NULL AND ($2::bigint = 0 OR c.id < $2) AND ` + commentVisible + `
+		ORDER BY c.id DESC
+		LIMIT $3;
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	rows, err := s.db.QueryContext(ctx, query, postID)
+	//one more than asked for tells us whether there is a next page
+	rows, err := s.db.QueryContext(ctx, query, postID, q.Before, q.Limit+1)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	comments := []Comment{}
-	for rows.Next() {
-		var c Comment
-		c.User = User{}
-		err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Content, &c.CreatedAt, &c.User.Username, &c.User.ID)
+	comments, err := scanComments(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCommentPage(comments, q.Limit), nil
+}
+
+// newCommentPage trims the extra comment we fetched to find out if there is a next page
+func newCommentPage(comments []Comment, limit int) *CommentPage {
+	page := &CommentPage{Comments: comments}
+	if len(comments) > limit {
+		page.Comments = comments[:limit]
+		page.NextCursor = encodeCursor(page.Comments[limit-1].ID)
+	}
+
+	return page
+}
+
+// GetThread fills in the replies of comment level by level, one query per level no matter how many
+// comments are on it
+func (s *CommentStore) GetThread(ctx context.Context, comment *Comment, q CommentThreadQuery) error {
+	level := []*Comment{comment}
+	page := q.CursorQuery
+
+	for depth := 0; depth < q.Depth; depth++ {
+		parents := make(map[int64]*Comment, len(level))
+		ids := make([]int64, 0, len(level))
+		for _, c := range level {
+			//leaves have nothing to fetch
+			if c.ReplyCount == 0 {
+				continue
+			}
+			parents[c.ID] = c
+			ids = append(ids, c.ID)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		replies, err := s.getReplies(ctx, ids, page)
 		if err != nil {
+			return err
+		}
+
+		grouped := make(map[int64][]Comment, len(ids))
+		for _, reply := range replies {
+			grouped[*reply.ParentID] = append(grouped[*reply.ParentID], reply)
+		}
+
+		level = level[:0]
+		for _, id := range ids {
+			parent := parents[id]
+			parent.Replies = newCommentPage(append([]Comment{}, grouped[id]...), page.Limit)
+			for i := range parent.Replies.Comments {
+				level = append(level, &parent.Replies.Comments[i])
+			}
+		}
+
+		//the cursor was for the direct replies only
+		page.Cursor = ""
+		page.Before = 0
+	}
+
+	return nil
+}
+
+// getReplies returns up to q.Limit+1 replies of every parent, newest first
+func (s *CommentStore) getReplies(ctx context.Context, parentIDs []int64, q CursorQuery) ([]Comment, error) {
+	query := `
+		SELECT ` + commentColumns + ` FROM comments c
+		LEFT JOIN users on users.id = c.user_id
+		JOIN (
+			SELECT c.id, ROW_NUMBER() OVER (PARTITION BY c.parent_id ORDER BY c.id DESC) AS rn
+			FROM comments c
+			LEFT JOIN users on users.id = c.user_id
+			WHERE c.parent_id = ANY($1) AND ($2::bigint = 0 OR c.id < $2) AND ` + commentVisible + `
+		) ranked ON ranked.id = c.id
+		WHERE ranked.rn <= $3
+		ORDER BY c.id DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(parentIDs), q.Before, q.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanComments(rows)
+}
+
+// commentCountQuery counts every comment still there on the post in postID, replies included
+func commentCountQuery(postID string) string {
+	return `
+		SELECT COUNT(*) FROM comments c
+		LEFT JOIN users on users.id = c.user_id
+		WHERE c.post_id = ` + postID + ` AND NOT ` + commentDeleted
+}
+
+// CountByPostID counts every comment still there, replies included
+func (s *CommentStore) CountByPostID(ctx context.Context, postID int64) (int, error) {
+	query := commentCountQuery("$1")
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, query, postID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (s *CommentStore) GetByID(ctx context.Context, commentID int64) (*Comment, error) {
+	query := `
+		SELECT ` + commentColumns + ` FROM comments c
+		LEFT JOIN users on users.id = c.user_id
+		WHERE c.id = $1 AND ` + commentVisible + `
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	c, err := scanComment(s.db.QueryRowContext(ctx, query, commentID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
 			return nil, err
 		}
-		comments = append(comments, c)
 	}
 
-	return comments, nil
+	return &c, nil
 }
 
 func (s *CommentStore) Create(ctx context.Context, comment *Comment) error {
 	query := `
-		INSERT INTO comments (post_id, user_id, content)
-		VALUES ($1, $2, $3)
-		RETURNING id, created_at
+		INSERT INTO comments (post_id, user_id, content, parent_id)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
@@ -66,9 +328,11 @@ func (s *CommentStore) Create(ctx context.Context, comment *Comment) error {
 		comment.PostID,
 		comment.UserID,
 		comment.Content,
+		comment.ParentID,
 	).Scan(
 		&comment.ID,
 		&comment.CreatedAt,
+		&comment.UpdatedAt,
 	)
 	if err != nil {
 		return err
@@ -76,3 +340,77 @@ func (s *CommentStore) Create(ctx context.Context, comment *Comment) error {
 
 	return nil
 }
+
+// Update changes the content of a comment, placeholders of deleted ones are not found
+func (s *CommentStore) Update(ctx context.Context, comment *Comment) error {
+	query := `
+		UPDATE comments
+		SET content = $1, updated_at = NOW()
+		WHERE id = $2 AND deleted_at IS NULL
+		RETURNING updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query, comment.Content, comment.ID).Scan(&comment.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete soft deletes a comment, the retention job purges it once it has no replies left
+func (s *CommentStore) Delete(ctx context.Context, commentID int64) error {
+	query := `UPDATE comments SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, commentID)
+}
+
+// Undelete brings back a soft deleted comment, until the retention job purged it
+func (s *CommentStore) Undelete(ctx context.Context, commentID int64) error {
+	query := `UPDATE comments SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, commentID)
+}
+
+// PurgeDeleted hard deletes comments deleted before deletedBefore. Placeholders go once their last reply
+// did, so the thread is purged from the leaves up
+func (s *CommentStore) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	query := `
+		DELETE FROM comments c
+		WHERE c.deleted_at < $1 AND NOT EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = c.id)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var purged int64
+	for {
+		res, err := s.db.ExecContext(ctx, query, deletedBefore)
+		if err != nil {
+			return purged, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return purged, err
+		}
+		if n == 0 {
+			return purged, nil
+		}
+		purged += n
+	}
+}
@@ -0,0 +1,17 @@
+package store
+
+import "testing"
+
+func TestNewCommentPage(t *testing.T) {
+	comments := []Comment{{ID: 9}, {ID: 7}, {ID: 4}}
+
+	page := newCommentPage(comments, 2)
+	if len(page.Comments) != 2 || page.NextCursor != encodeCursor(7) {
+		t.Errorf("expected 2 comments and a cursor after 7, got %+v", page)
+	}
+
+	page = newCommentPage(comments, 3)
+	if len(page.Comments) != 3 || page.NextCursor != "" {
+		t.Errorf("expected the last page, got %+v", page)
+	}
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// EmailChange is a confirmed email change, OldEmail is where the account was registered before
+type EmailChange struct {
+	UserID   int64
+	Username string
+	OldEmail string
+	NewEmail string
+}
+
+// RequestEmailChange stores a pending change to newEmail, replacing any earlier request of the user.
+// ErrDuplicateEmail if another account already uses newEmail
+func (s *UserStore) RequestEmailChange(ctx context.Context, userID int64, newEmail, token string, exp time.Duration) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		//email is citext, so this is case insensitive like the unique constraint
+		var taken bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`, newEmail).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrDuplicateEmail
+		}
+
+		if err := deleteEmailChangeRequests(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO email_change_requests (token, user_id, new_email, expiry) VALUES ($1, $2, $3, $4)`
+		_, err = tx.ExecContext(ctx, query, hashToken(token), userID, newEmail, time.Now().Add(exp))
+		return err
+	})
+}
+
+// ConfirmEmailChange swaps in the new email of the request belonging to token. The address could have been
+// registered by someone else in the meantime, that is ErrDuplicateEmail
+func (s *UserStore) ConfirmEmailChange(ctx context.Context, token string) (*EmailChange, error) {
+	change := &EmailChange{}
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+		SELECT u.id, u.username, u.email, ecr.new_email
+		FROM email_change_requests ecr
+		JOIN users u ON (u.id = ecr.user_id)
+		WHERE ecr.token = $1 AND ecr.expiry > $2
+		FOR UPDATE
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(ctx, query, hashToken(token), time.Now()).Scan(
+			&change.UserID, &change.Username, &change.OldEmail, &change.NewEmail,
+		)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE users SET email = $1 WHERE id = $2`, change.NewEmail, change.UserID)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+				return ErrDuplicateEmail
+			default:
+				return err
+			}
+		}
+
+		return deleteEmailChangeRequests(ctx, tx, change.UserID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
+
+func deleteEmailChangeRequests(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `DELETE FROM email_change_requests WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	return err
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Identity links an account at an OpenID Connect provider to one of our users
+type Identity struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	Provider  string `json:"provider"`
+	Subject   string `json:"-"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"created_at"`
+}
+
+// GetByIdentity returns the user linked to provider + subject, inactive users included so the caller can tell
+// "never seen" from "not activated yet"
+func (s *UserStore) GetByIdentity(ctx context.Context, provider, subject string) (*User, error) {
+	query := `
+	SELECT u.id, u.username, u.email, u.created_at, u.is_active, u.totp_enabled, u.failed_login_attempts, u.locked_until
+	FROM users u
+	JOIN user_identities ui ON (u.id = ui.user_id)
+	WHERE ui.provider = $1 AND ui.subject = $2 AND u.deleted_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	user := &User{}
+	err := s.db.QueryRowContext(ctx, query, provider, subject).Scan(
+		&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.IsActive,
+		&user.MFAEnabled, &user.FailedLoginAttempts, &user.LockedUntil,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// LinkIdentity attaches identity to the existing user with the same email and activates that user, the
+// provider verified the email so it proves the same as the invitation link. ErrNotFound if there is no such user
+func (s *UserStore) LinkIdentity(ctx context.Context, identity *Identity) (*User, error) {
+	var user *User
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+		SELECT id, username, email, created_at, is_active, totp_enabled, failed_login_attempts, locked_until
+		FROM users WHERE email = $1 FOR UPDATE
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		user = &User{}
+		err := tx.QueryRowContext(ctx, query, identity.Email).Scan(
+			&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.IsActive,
+			&user.MFAEnabled, &user.FailedLoginAttempts, &user.LockedUntil,
+		)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		identity.UserID = user.ID
+		if err := createIdentity(ctx, tx, identity); err != nil {
+			return err
+		}
+
+		if !user.IsActive {
+			user.IsActive = true
+			if err := s.update(ctx, tx, user); err != nil {
+				return err
+			}
+
+			return s.deleteUserInvitations(ctx, tx, user.ID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// CreateWithIdentity registers a new user for identity. Without an invitation token the user is active right
+// away (verified email), with one it has to be activated like any other registration
+func (s *UserStore) CreateWithIdentity(ctx context.Context, user *User, identity *Identity, token string, invitationExp time.Duration) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.Create(ctx, tx, user); err != nil {
+			return err
+		}
+
+		if token == "" {
+			user.IsActive = true
+			if err := s.update(ctx, tx, user); err != nil {
+				return err
+			}
+		} else {
+			if err := s.createUserInvitation(ctx, tx, token, invitationExp, user.ID); err != nil {
+				return err
+			}
+		}
+
+		identity.UserID = user.ID
+		return createIdentity(ctx, tx, identity)
+	})
+}
+
+func createIdentity(ctx context.Context, tx *sql.Tx, identity *Identity) error {
+	query := `
+	INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := tx.QueryRowContext(ctx, query, identity.UserID, identity.Provider, identity.Subject, identity.Email).Scan(
+		&identity.ID,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "user_identities_provider_subject_key"`:
+			return ErrConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
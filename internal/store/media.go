@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrMediaUnavailable = errors.New("media not found or already attached to a post")
+
+// Media is an uploaded image. The bytes are in the blob store, uploads that aren't attached to a
+// post in time are garbage collected
+type Media struct {
+	ID     int64  `json:"id"`
+	PostID *int64 `json:"post_id"`
+	//0 once the uploader was deleted
+	UserID       int64  `json:"user_id"`
+	Key          string `json:"-"`
+	ThumbnailKey string `json:"-"`
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	CreatedAt    string `json:"created_at"`
+}
+
+type MediaStore struct {
+	db *sql.DB
+}
+
+const mediaColumns = `id, post_id, COALESCE(user_id, 0), key, thumbnail_key, content_type, size, width, height, created_at`
+
+func scanMedia(row rowScanner) (Media, error) {
+	var m Media
+	var postID sql.NullInt64
+	err := row.Scan(&m.ID, &postID, &m.UserID, &m.Key, &m.ThumbnailKey, &m.ContentType, &m.Size, &m.Width, &m.Height, &m.CreatedAt)
+	if err != nil {
+		return m, err
+	}
+	if postID.Valid {
+		m.PostID = &postID.Int64
+	}
+
+	return m, nil
+}
+
+// Create records an upload whose blobs are stored already, it isn't attached to a post yet
+func (s *MediaStore) Create(ctx context.Context, m *Media) error {
+	query := `
+		INSERT INTO media (user_id, key, thumbnail_key, content_type, size, width, height)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(ctx, query, m.UserID, m.Key, m.ThumbnailKey, m.ContentType, m.Size, m.Width, m.Height).Scan(&m.ID, &m.CreatedAt)
+}
+
+func (s *MediaStore) GetByID(ctx context.Context, id int64) (*Media, error) {
+	query := `SELECT ` + mediaColumns + ` FROM media WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	m, err := scanMedia(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &m, nil
+}
+
+// DeleteOrphaned deletes uploads created before createdBefore that still aren't attached to a post and
+// returns them, so their blobs can be deleted too. A batch at a time, the job comes by again
+func (s *MediaStore) DeleteOrphaned(ctx context.Context, createdBefore time.Time) ([]Media, error) {
+	query := `
+		DELETE FROM media
+		WHERE id IN (SELECT id FROM media WHERE post_id IS NULL AND created_at < $1 ORDER BY id LIMIT 1000)
+		RETURNING ` + mediaColumns
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, createdBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var media []Media
+	for rows.Next() {
+		m, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		media = append(media, m)
+	}
+
+	return media, rows.Err()
+}
+
+// attachMedia attaches the uploads in post.Media (only their IDs are set) to the post and loads them. They
+// have to be uploaded by the author and not attached anywhere yet, ErrMediaUnavailable otherwise
+func attachMedia(ctx context.Context, tx *sql.Tx, post *Post) error {
+	if len(post.Media) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(post.Media))
+	for i, m := range post.Media {
+		ids[i] = m.ID
+	}
+
+	query := `
+		WITH attached AS (
+			UPDATE media SET post_id = $1
+			WHERE id = ANY($2) AND user_id = $3 AND post_id IS NULL
+			RETURNING *
+		)
+		SELECT ` + mediaColumns + ` FROM attached ORDER BY id
+	`
+
+	rows, err := tx.QueryContext(ctx, query, post.ID, pq.Array(ids), post.UserID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	post.Media = []Media{}
+	for rows.Next() {
+		m, err := scanMedia(rows)
+		if err != nil {
+			return err
+		}
+		post.Media = append(post.Media, m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(post.Media) != len(ids) {
+		return ErrMediaUnavailable
+	}
+
+	return nil
+}
+
+// mediaByPostID loads the media of many posts in one query, for feeds and lists
+func mediaByPostID(ctx context.Context, db *sql.DB, postIDs []int64) (map[int64][]Media, error) {
+	media := make(map[int64][]Media, len(postIDs))
+	if len(postIDs) == 0 {
+		return media, nil
+	}
+
+	query := `SELECT ` + mediaColumns + ` FROM media WHERE post_id = ANY($1) ORDER BY post_id, id`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		media[*m.PostID] = append(media[*m.PostID], m)
+	}
+
+	return media, rows.Err()
+}
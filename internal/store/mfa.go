@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// TOTP is the second factor of a user, Secret is set during enrollment before Enabled is
+type TOTP struct {
+	UserID   int64
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+// MFAStore keeps the TOTP secret encrypted with secrets, a database dump alone can't generate codes
+type MFAStore struct {
+	db      *sql.DB
+	secrets *secretBox
+}
+
+func (s *MFAStore) Get(ctx context.Context, userID int64) (*TOTP, error) {
+	query := `SELECT id, COALESCE(totp_secret, ''), totp_enabled, totp_last_step FROM users WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	totp := &TOTP{}
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&totp.UserID, &totp.Secret, &totp.Enabled, &totp.LastStep)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if totp.Secret != "" {
+		if totp.Secret, err = s.secrets.open(totp.Secret); err != nil {
+			return nil, err
+		}
+	}
+
+	return totp, nil
+}
+
+// SetPendingSecret starts (or restarts) an enrollment, it fails with ErrConflict when TOTP is already enabled
+func (s *MFAStore) SetPendingSecret(ctx context.Context, userID int64, secret string) error {
+	query := `UPDATE users SET totp_secret = $2 WHERE id = $1 AND totp_enabled = false`
+
+	sealed, err := s.secrets.seal(secret)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, sealed)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// Enable finishes the enrollment once the user proved the app works, step is the time step of that code.
+// recoveryCodes replace any codes from an earlier enrollment
+func (s *MFAStore) Enable(ctx context.Context, userID int64, step int64, recoveryCodes []string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+		UPDATE users SET totp_enabled = true, totp_last_step = $2
+		WHERE id = $1 AND totp_enabled = false AND totp_secret IS NOT NULL
+		`
+		res, err := tx.ExecContext(ctx, query, userID, step)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return ErrConflict
+		}
+
+		return replaceRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	})
+}
+
+// Disable removes the secret and all recovery codes
+func (s *MFAStore) Disable(ctx context.Context, userID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `UPDATE users SET totp_enabled = false, totp_secret = NULL, totp_last_step = 0 WHERE id = $1`
+		_, err := tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+
+		return replaceRecoveryCodes(ctx, tx, userID, nil)
+	})
+}
+
+// UseStep records step as the last accepted one, a code of the same or an earlier step returns ErrConflict
+func (s *MFAStore) UseStep(ctx context.Context, userID int64, step int64) error {
+	query := `UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// UseRecoveryCode burns one of the recovery codes, ErrNotFound if it doesn't exist or was used already
+func (s *MFAStore) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	query := `UPDATE user_recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, hashToken(code))
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64, codes []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	//recovery codes are random enough that a sha256 like our other tokens is fine
+	for _, code := range codes {
+		_, err := tx.ExecContext(ctx, `INSERT INTO user_recovery_codes (user_id, code) VALUES ($1, $2)`, userID, hashToken(code))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
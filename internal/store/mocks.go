@@ -3,6 +3,8 @@ package store
 import (
 	"context"
 	"database/sql"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -10,31 +12,732 @@ import (
 
 func NewMockStore() Storage {
 	return Storage{
-		Users: &MockUserStore{},
+		Users:         &MockUserStore{},
+		Posts:         &MockPostStore{},
+		Comments:      &MockCommentStore{},
+		Reactions:     &MockReactionStore{},
+		PostRevisions: &MockPostRevisionStore{},
+		Media:         &MockMediaStore{},
+		Roles:         &MockRoleStore{},
+		RefreshTokens: &MockRefreshTokenStore{},
+		APIKeys:       &MockAPIKeyStore{},
+		RevokedTokens: &MockRevokedTokenStore{},
+		Sessions:      &MockSessionStore{},
+		Audit:         &MockAuditStore{},
 	}
 }
 
-type MockUserStore struct{}
+// MockUserStore returns the same zero user for every lookup, it keeps that user's failed logins
+// and the single use tokens created for it so the lockout and token flows can be tested
+type MockUserStore struct {
+	//when set, GetByEmail only finds this address
+	Email string
+	//the user is activated
+	Active bool
+	//createdBefore of the last PurgeUnactivated
+	PurgedBefore time.Time
+	//when set, ComparePassword accepts this password
+	Password string
+
+	mu           sync.Mutex
+	attempts     int
+	lockedUntil  *time.Time
+	tokens       map[string]mockUserToken
+	emailChanges map[string]mockUserToken
+}
+
+// invitations are kept with the user tokens under this scope, by their hash like in user_invitations
+const mockInvitationScope = "invitation"
+
+type mockUserToken struct {
+	userID int64
+	scope  string
+	expiry time.Time
+	//the new address of an email change
+	email string
+}
+
+func (m *MockUserStore) user() *User {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return &User{Email: m.Email, Role: Role{Name: "user"}, IsActive: m.Active, FailedLoginAttempts: m.attempts, LockedUntil: m.lockedUntil}
+}
+
+// Token returns a token that is still stored for scope, or "" if there is none
+func (m *MockUserStore) Token(scope string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for token, t := range m.tokens {
+		if t.scope == scope {
+			return token
+		}
+	}
+	return ""
+}
+
+// redeem removes every token of the owner of token in scope, like deleteUserTokens
+func (m *MockUserStore) redeem(scope, token string) (int64, error) {
+	t, ok := m.tokens[token]
+	if !ok || t.scope != scope || t.expiry.Before(time.Now()) {
+		return 0, ErrNotFound
+	}
+
+	for k, other := range m.tokens {
+		if other.scope == scope && other.userID == t.userID {
+			delete(m.tokens, k)
+		}
+	}
+	return t.userID, nil
+}
 
 func (m *MockUserStore) Create(context.Context, *sql.Tx, *User) error {
 	return nil
 }
 
 func (m *MockUserStore) GetByID(context.Context, int64) (*User, error) {
-	return &User{}, nil
+	return m.user(), nil
 }
-func (m *MockUserStore) GetByEmail(context.Context, string) (*User, error) {
-	return &User{}, nil
+func (m *MockUserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if m.Email != "" && !strings.EqualFold(email, m.Email) {
+		return nil, ErrNotFound
+	}
+	return m.user(), nil
 }
 
-func (m *MockUserStore) CreateAndInvite(ctx context.Context, user *User, token string, exp time.Duration) error {
+// ComparePassword only matches the Password knob, without it the mock user has no password
+func (m *MockUserStore) ComparePassword(user *User, text string) error {
+	if m.Password == "" || text != m.Password {
+		return ErrPasswordMismatch
+	}
 	return nil
 }
 
-func (m *MockUserStore) Activate(context.Context, string) error {
+func (m *MockUserStore) CreateAndInvite(ctx context.Context, user *User, token string, exp time.Duration) error {
 	return nil
 }
 
+func (m *MockUserStore) Activate(ctx context.Context, token string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userID, err := m.redeem(mockInvitationScope, hashToken(token))
+	if err != nil {
+		return 0, err
+	}
+
+	m.Active = true
+	return userID, nil
+}
+
 func (m *MockUserStore) Delete(context.Context, int64) error {
 	return nil
 }
+
+func (m *MockUserStore) Undelete(context.Context, int64) error {
+	return nil
+}
+
+func (m *MockUserStore) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (m *MockUserStore) RecordFailedLogin(ctx context.Context, userID int64, maxAttempts int, lockout time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.attempts++
+	if m.attempts < maxAttempts {
+		return false, nil
+	}
+
+	until := time.Now().Add(lockout)
+	m.attempts, m.lockedUntil = 0, &until
+	return true, nil
+}
+
+func (m *MockUserStore) ResetFailedLogins(context.Context, int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.attempts, m.lockedUntil = 0, nil
+	return nil
+}
+
+func (m *MockUserStore) CreateToken(ctx context.Context, userID int64, scope, token string, exp time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tokens == nil {
+		m.tokens = make(map[string]mockUserToken)
+	}
+	m.tokens[token] = mockUserToken{userID: userID, scope: scope, expiry: time.Now().Add(exp)}
+	return nil
+}
+
+func (m *MockUserStore) Unlock(ctx context.Context, token string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userID, err := m.redeem(ScopeUnlock, token)
+	if err != nil {
+		return 0, err
+	}
+
+	m.attempts, m.lockedUntil = 0, nil
+	return userID, nil
+}
+
+func (m *MockUserStore) RedeemMagicLink(context.Context, string) (int64, error) {
+	return 1, nil
+}
+
+func (m *MockUserStore) ResetPassword(ctx context.Context, token string, user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userID, err := m.redeem(ScopePasswordReset, token)
+	if err != nil {
+		return err
+	}
+	user.ID = userID
+
+	for k, t := range m.tokens {
+		if t.userID == userID {
+			delete(m.tokens, k)
+		}
+	}
+	m.attempts, m.lockedUntil = 0, nil
+	return nil
+}
+
+func (m *MockUserStore) GetByIdentity(ctx context.Context, provider, subject string) (*User, error) {
+	return nil, ErrNotFound
+}
+
+func (m *MockUserStore) LinkIdentity(context.Context, *Identity) (*User, error) {
+	return nil, ErrNotFound
+}
+
+func (m *MockUserStore) CreateWithIdentity(ctx context.Context, user *User, identity *Identity, token string, exp time.Duration) error {
+	return nil
+}
+
+func (m *MockUserStore) RotateInvitation(ctx context.Context, email, token string, exp time.Duration) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Active || !strings.EqualFold(email, m.Email) {
+		return nil, ErrNotFound
+	}
+
+	for k, t := range m.tokens {
+		if t.scope == mockInvitationScope {
+			delete(m.tokens, k)
+		}
+	}
+	if m.tokens == nil {
+		m.tokens = make(map[string]mockUserToken)
+	}
+	m.tokens[token] = mockUserToken{scope: mockInvitationScope, expiry: time.Now().Add(exp)}
+
+	return &User{Username: "gopher", Email: m.Email}, nil
+}
+
+// Invitations returns how many invitations are stored
+func (m *MockUserStore) Invitations() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, t := range m.tokens {
+		if t.scope == mockInvitationScope {
+			n++
+		}
+	}
+	return n
+}
+
+func (m *MockUserStore) PurgeExpiredInvitations(context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for k, t := range m.tokens {
+		if t.scope == mockInvitationScope && t.expiry.Before(time.Now()) {
+			delete(m.tokens, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MockUserStore) PurgeUnactivated(ctx context.Context, createdBefore time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.PurgedBefore = createdBefore
+	return 0, nil
+}
+
+func (m *MockUserStore) RequestEmailChange(ctx context.Context, userID int64, newEmail, token string, exp time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//replaces the earlier request like RequestEmailChange
+	m.emailChanges = map[string]mockUserToken{
+		token: {userID: userID, expiry: time.Now().Add(exp), email: newEmail},
+	}
+	return nil
+}
+
+func (m *MockUserStore) ConfirmEmailChange(ctx context.Context, token string) (*EmailChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	change, ok := m.emailChanges[token]
+	delete(m.emailChanges, token)
+	if !ok || change.expiry.Before(time.Now()) {
+		return nil, ErrNotFound
+	}
+
+	old := m.Email
+	m.Email = change.email
+	return &EmailChange{UserID: change.userID, OldEmail: old, NewEmail: change.email}, nil
+}
+
+func (m *MockUserStore) UpdatePassword(context.Context, *User) error {
+	return nil
+}
+
+// MockRefreshTokenStore keeps refresh tokens in memory and rotates them by the rules of RefreshTokenStore
+type MockRefreshTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]*RefreshToken
+}
+
+func (m *MockRefreshTokenStore) Create(ctx context.Context, token string, userID int64, familyID string, exp time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tokens == nil {
+		m.tokens = make(map[string]*RefreshToken)
+	}
+	m.tokens[token] = &RefreshToken{UserID: userID, FamilyID: familyID, Expiry: time.Now().Add(exp), CreatedAt: time.Now()}
+	return nil
+}
+
+func (m *MockRefreshTokenStore) Rotate(ctx context.Context, token, newToken string, exp time.Duration) (*RefreshToken, error) {
+	m.mu.Lock()
+	current, ok := m.tokens[token]
+	if !ok {
+		m.mu.Unlock()
+		return nil, ErrNotFound
+	}
+
+	err := current.checkRotatable(time.Now())
+	if err == nil {
+		now := time.Now()
+		current.UsedAt = &now
+	}
+	rotated := *current
+	m.mu.Unlock()
+
+	if err != nil {
+		if err == ErrTokenReused {
+			m.RevokeFamily(ctx, current.FamilyID)
+			return &rotated, err
+		}
+		return nil, err
+	}
+
+	return &rotated, m.Create(ctx, newToken, rotated.UserID, rotated.FamilyID, exp)
+}
+
+func (m *MockRefreshTokenStore) RevokeFamily(ctx context.Context, familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, t := range m.tokens {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *MockRefreshTokenStore) RevokeAllForUser(ctx context.Context, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, t := range m.tokens {
+		if t.UserID == userID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// MockAPIKeyStore knows a single key, "gsk_test", which may only read posts. It is gone once the
+// keys of any user are revoked, the mock stores only have one user
+type MockAPIKeyStore struct {
+	mu      sync.Mutex
+	revoked bool
+}
+
+func (m *MockAPIKeyStore) Create(ctx context.Context, key *APIKey, plainKey string) error {
+	return nil
+}
+
+func (m *MockAPIKeyStore) GetByUserID(context.Context, int64) ([]APIKey, error) {
+	return []APIKey{}, nil
+}
+
+func (m *MockAPIKeyStore) GetByKey(ctx context.Context, plainKey string) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if plainKey != "gsk_test" || m.revoked {
+		return nil, ErrNotFound
+	}
+	return &APIKey{ID: 1, UserID: 1, Scopes: []string{"posts:read"}}, nil
+}
+
+func (m *MockAPIKeyStore) Revoke(ctx context.Context, keyID, userID int64) error {
+	return nil
+}
+
+func (m *MockAPIKeyStore) RevokeAllForUser(ctx context.Context, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revoked = true
+	return nil
+}
+
+// MockRevokedTokenStore only remembers the logout everywhere watermarks and the revoked families
+type MockRevokedTokenStore struct {
+	mu       sync.Mutex
+	before   map[int64]time.Time
+	families map[string]bool
+}
+
+func (m *MockRevokedTokenStore) Revoke(ctx context.Context, jti string, exp time.Time) error {
+	return nil
+}
+
+func (m *MockRevokedTokenStore) IsRevoked(context.Context, string) (bool, error) {
+	return false, nil
+}
+
+func (m *MockRevokedTokenStore) RevokeIssuedBefore(ctx context.Context, userID int64, t time.Time, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.before == nil {
+		m.before = make(map[int64]time.Time)
+	}
+	m.before[userID] = t
+	return nil
+}
+
+func (m *MockRevokedTokenStore) IssuedBefore(ctx context.Context, userID int64) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.before[userID], nil
+}
+
+func (m *MockRevokedTokenStore) RevokeFamily(ctx context.Context, familyID string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.families == nil {
+		m.families = make(map[string]bool)
+	}
+	m.families[familyID] = true
+	return nil
+}
+
+func (m *MockRevokedTokenStore) IsFamilyRevoked(ctx context.Context, familyID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.families[familyID], nil
+}
+
+func (m *MockRevokedTokenStore) PurgeExpired(context.Context) (int64, error) {
+	return 0, nil
+}
+
+// MockRoleStore grants every role the permissions of a plain user, plus Permissions
+type MockRoleStore struct {
+	Permissions []string
+}
+
+func (m *MockRoleStore) GetByName(ctx context.Context, name string) (*Role, error) {
+	return &Role{Name: name}, nil
+}
+
+func (m *MockRoleStore) GetPermissions(context.Context, int64) ([]string, error) {
+	return append([]string{PermPostsCreate, PermCommentsCreate}, m.Permissions...), nil
+}
+
+func (m *MockRoleStore) GetUserIDs(context.Context, int64) ([]int64, error) {
+	return []int64{1, 2}, nil
+}
+
+func (m *MockRoleStore) GetAll(context.Context) ([]Role, error) {
+	return []Role{}, nil
+}
+
+func (m *MockRoleStore) GetByID(ctx context.Context, roleID int64) (*Role, error) {
+	return &Role{ID: roleID}, nil
+}
+
+func (m *MockRoleStore) Create(context.Context, *Role) error {
+	return nil
+}
+
+func (m *MockRoleStore) Update(context.Context, *Role) error {
+	return nil
+}
+
+func (m *MockRoleStore) AssignToUser(ctx context.Context, userID, roleID int64) (int64, error) {
+	return 1, nil
+}
+
+// MockAuditStore keeps the events so tests can check what was recorded
+type MockAuditStore struct {
+	mu     sync.Mutex
+	Events []AuditEvent
+}
+
+func (m *MockAuditStore) Create(ctx context.Context, events []*AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range events {
+		m.Events = append(m.Events, *e)
+	}
+	return nil
+}
+
+func (m *MockAuditStore) List(context.Context, AuditQuery) ([]AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]AuditEvent{}, m.Events...), nil
+}
+
+// Actions returns the recorded actions in order
+func (m *MockAuditStore) Actions() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	actions := make([]string, 0, len(m.Events))
+	for _, e := range m.Events {
+		actions = append(actions, e.Action)
+	}
+	return actions
+}
+
+// MockSessionStore has a single session, the one of the test token
+type MockSessionStore struct{}
+
+func (m *MockSessionStore) Create(context.Context, *Session, string, time.Duration) error {
+	return nil
+}
+
+func (m *MockSessionStore) GetActiveByUserID(ctx context.Context, userID int64) ([]Session, error) {
+	return []Session{{ID: "test-session", UserID: userID}}, nil
+}
+
+func (m *MockSessionStore) Revoke(ctx context.Context, sessionID string, userID int64) error {
+	return nil
+}
+
+func (m *MockSessionStore) Touch(ctx context.Context, seen map[string]time.Time) error {
+	return nil
+}
+
+func (m *MockSessionStore) PurgeEnded(ctx context.Context, seenBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+// MockPostStore finds every post it is asked for, written by OwnerID
+type MockPostStore struct {
+	OwnerID int64
+	//every write loses against a concurrent one
+	Conflict bool
+	//every post is a draft
+	Draft bool
+}
+
+func (m *MockPostStore) GetByID(ctx context.Context, postID int64) (*Post, error) {
+	post := &Post{ID: postID, UserID: m.OwnerID, Draft: m.Draft}
+	if !m.Draft {
+		//fixed, so the post looks the same on every request
+		published := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+		post.PublishedAt = &published
+	}
+	return post, nil
+}
+
+func (m *MockPostStore) Create(context.Context, *Post) error {
+	return nil
+}
+
+func (m *MockPostStore) Delete(ctx context.Context, postID int64, version int) error {
+	if m.Conflict {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+func (m *MockPostStore) Undelete(context.Context, int64) error {
+	return nil
+}
+
+func (m *MockPostStore) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (m *MockPostStore) Update(ctx context.Context, post *Post, editorID int64) error {
+	if m.Conflict {
+		return ErrEditConflict
+	}
+	post.Version++
+	post.Edited = true
+	return nil
+}
+
+func (m *MockPostStore) Restore(ctx context.Context, post *Post, version int, editorID int64) error {
+	if version > post.Version {
+		return ErrNotFound
+	}
+	post.Version++
+	post.Edited = true
+	return nil
+}
+
+func (m *MockPostStore) GetDrafts(ctx context.Context, userID int64, q CursorQuery) (*PostPage, error) {
+	return &PostPage{Posts: []Post{}}, nil
+}
+
+func (m *MockPostStore) Schedule(ctx context.Context, postID int64, publishAt *time.Time) error {
+	if !m.Draft {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *MockPostStore) Publish(context.Context, int64) error {
+	if !m.Draft {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *MockPostStore) PublishDue(context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (m *MockPostStore) GetUserFeed(ctx context.Context, userID int64, fq PaginatedFeedQuery) ([]PostWithMetadata, error) {
+	return []PostWithMetadata{}, nil
+}
+
+// MockMediaStore has an unattached PNG uploaded by OwnerID under every ID, the blob is up to the test
+type MockMediaStore struct {
+	OwnerID int64
+}
+
+func (m *MockMediaStore) Create(ctx context.Context, media *Media) error {
+	media.ID = 1
+	return nil
+}
+
+func (m *MockMediaStore) GetByID(ctx context.Context, id int64) (*Media, error) {
+	return &Media{ID: id, UserID: m.OwnerID, Key: "media/test", ThumbnailKey: "media/test_thumb", ContentType: "image/png"}, nil
+}
+
+func (m *MockMediaStore) DeleteOrphaned(ctx context.Context, createdBefore time.Time) ([]Media, error) {
+	return nil, nil
+}
+
+// MockCommentStore keeps every comment on post 1, written by OwnerID
+type MockCommentStore struct {
+	OwnerID int64
+	//every comment is a placeholder of a deleted one
+	Deleted bool
+}
+
+func (m *MockCommentStore) Create(context.Context, *Comment) error {
+	return nil
+}
+
+func (m *MockCommentStore) GetByPostID(ctx context.Context, postID int64, q CursorQuery) (*CommentPage, error) {
+	return &CommentPage{Comments: []Comment{}}, nil
+}
+
+func (m *MockCommentStore) CountByPostID(context.Context, int64) (int, error) {
+	return 0, nil
+}
+
+func (m *MockCommentStore) GetByID(ctx context.Context, commentID int64) (*Comment, error) {
+	return &Comment{ID: commentID, PostID: 1, UserID: m.OwnerID, Deleted: m.Deleted}, nil
+}
+
+func (m *MockCommentStore) GetThread(ctx context.Context, comment *Comment, q CommentThreadQuery) error {
+	return nil
+}
+
+func (m *MockCommentStore) Update(context.Context, *Comment) error {
+	return nil
+}
+
+func (m *MockCommentStore) Delete(context.Context, int64) error {
+	return nil
+}
+
+func (m *MockCommentStore) Undelete(context.Context, int64) error {
+	return nil
+}
+
+func (m *MockCommentStore) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+type MockReactionStore struct {
+	//counts GetSummary returns
+	Reactions map[string]int
+}
+
+func (m *MockReactionStore) React(ctx context.Context, postID, userID int64, kind string) error {
+	return nil
+}
+
+func (m *MockReactionStore) Unreact(ctx context.Context, postID, userID int64, kind string) error {
+	return nil
+}
+
+func (m *MockReactionStore) GetSummary(ctx context.Context, postID, userID int64) (*ReactionSummary, error) {
+	reactions := m.Reactions
+	if reactions == nil {
+		reactions = map[string]int{}
+	}
+	return &ReactionSummary{Reactions: reactions, MyReactions: []string{}}, nil
+}
+
+func (m *MockReactionStore) GetByPostID(ctx context.Context, postID int64, q ReactionQuery) (*ReactionPage, error) {
+	return &ReactionPage{Reactions: []Reaction{}}, nil
+}
+
+type MockPostRevisionStore struct{}
+
+func (m *MockPostRevisionStore) GetByPostID(ctx context.Context, postID int64, q CursorQuery) (*PostRevisionPage, error) {
+	return &PostRevisionPage{Revisions: []PostRevision{}}, nil
+}
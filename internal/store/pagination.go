@@ -1,6 +1,8 @@
 package store
 
 import (
+	"encoding/base64"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
@@ -77,3 +79,56 @@ func parseTime(s string) string {
 
 	return t.Format(time.DateTime)
 }
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// CursorQuery pages through comments and reactions newest first. Offsets shift when somebody adds one
+// while you scroll, so the next page starts below the last id we handed out instead
+type CursorQuery struct {
+	Limit  int    `json:"limit" validate:"gte=1,lte=50"`
+	Cursor string `json:"cursor"`
+	//decoded from Cursor by Parse, 0 starts at the newest one
+	Before int64 `json:"-"`
+}
+
+func (q CursorQuery) Parse(r *http.Request) (CursorQuery, error) {
+	qs := r.URL.Query()
+
+	if limit := qs.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return q, err
+		}
+		q.Limit = l
+	}
+
+	if cursor := qs.Get("cursor"); cursor != "" {
+		before, err := decodeCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+		q.Cursor = cursor
+		q.Before = before
+	}
+
+	return q, nil
+}
+
+// cursors are opaque to clients so we can change what is inside without breaking them
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || id < 1 {
+		return 0, ErrInvalidCursor
+	}
+
+	return id, nil
+}
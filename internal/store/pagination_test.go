@@ -0,0 +1,25 @@
+package store
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCursorQuery(t *testing.T) {
+	r := httptest.NewRequest("GET", "/v1/posts/1/comments?limit=5&cursor="+encodeCursor(42), nil)
+
+	q, err := CursorQuery{Limit: 20}.Parse(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Limit != 5 || q.Before != 42 {
+		t.Errorf("expected limit 5 before 42, got %+v", q)
+	}
+
+	for _, cursor := range []string{"not-a-cursor!", encodeCursor(0), "LTE"} {
+		r := httptest.NewRequest("GET", "/v1/posts/1/comments?cursor="+cursor, nil)
+		if _, err := (CursorQuery{}).Parse(r); err != ErrInvalidCursor {
+			t.Errorf("cursor %q: expected ErrInvalidCursor, got %v", cursor, err)
+		}
+	}
+}
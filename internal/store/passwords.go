@@ -0,0 +1,193 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errUnknownHashFormat = errors.New("unknown password hash format")
+
+// Argon2Params tunes argon2id, Memory is in KiB. Changing them makes every older hash NeedsRehash
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params are the second recommendation of RFC 9106 with a bit more memory
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// passwordHasher makes new hashes with params, and compares against argon2id and the bcrypt hashes
+// from before it
+type passwordHasher struct {
+	params Argon2Params
+	//compared against instead of a hash that isn't there, see compare
+	dummyArgon2id func() []byte
+	dummyBcrypt   func() []byte
+}
+
+func newPasswordHasher(params Argon2Params) *passwordHasher {
+	const dummyPassword = "gophersocial-dummy-password"
+
+	return &passwordHasher{
+		params: params,
+		dummyArgon2id: sync.OnceValue(func() []byte {
+			hash, _ := hashArgon2id(dummyPassword, params)
+			return hash
+		}),
+		//the cost the legacy hashes were made with
+		dummyBcrypt: sync.OnceValue(func() []byte {
+			hash, _ := bcrypt.GenerateFromPassword([]byte(dummyPassword), bcrypt.DefaultCost)
+			return hash
+		}),
+	}
+}
+
+// hash replaces the plaintext set with password.SetPlaintext by its argon2id hash, nothing to do without one
+func (h *passwordHasher) hash(p *password) error {
+	if p.text == nil {
+		return nil
+	}
+
+	hash, err := hashArgon2id(*p.text, h.params)
+	if err != nil {
+		return err
+	}
+
+	p.text = nil
+	p.hash = hash
+	p.outdated = false
+	return nil
+}
+
+// compare checks text against the hash of p and marks p outdated if it should be rehashed.
+// Every call does one argon2id and one bcrypt comparison, with dummy hashes for what p doesn't have,
+// so an unknown email, a legacy bcrypt hash and an argon2id hash all take the same time
+func (h *passwordHasher) compare(p *password, text string) error {
+	argon2idHash, bcryptHash := h.dummyArgon2id(), h.dummyBcrypt()
+
+	switch {
+	case len(p.hash) == 0:
+	case isArgon2id(p.hash):
+		argon2idHash = p.hash
+	case isBcrypt(p.hash):
+		bcryptHash = p.hash
+	default:
+		return errUnknownHashFormat
+	}
+
+	argon2idErr := compareArgon2id(argon2idHash, text)
+	bcryptErr := bcrypt.CompareHashAndPassword(bcryptHash, []byte(text))
+	if errors.Is(bcryptErr, bcrypt.ErrMismatchedHashAndPassword) {
+		bcryptErr = ErrPasswordMismatch
+	}
+
+	switch {
+	case len(p.hash) == 0:
+		//an empty hash never matches, even if the text happens to be the dummy password
+		return ErrPasswordMismatch
+	case isBcrypt(p.hash):
+		p.outdated = true
+		return bcryptErr
+	default:
+		params, _, _, _ := decodeArgon2id(p.hash)
+		p.outdated = params != h.params
+		return argon2idErr
+	}
+}
+
+// hashArgon2id returns a PHC string like $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>, it carries its own
+// parameters so they can change without breaking the stored hashes
+func hashArgon2id(text string, p Argon2Params) ([]byte, error) {
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(text), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	return []byte(encoded), nil
+}
+
+// decodeArgon2id parses a PHC string from hashArgon2id back into parameters, salt and key
+func decodeArgon2id(hash []byte) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, errUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, err
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, err
+	}
+
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+
+	return p, salt, key, nil
+}
+
+func compareArgon2id(hash []byte, text string) error {
+	p, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(text), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+func isArgon2id(hash []byte) bool {
+	return strings.HasPrefix(string(hash), "$argon2id$")
+}
+
+// bcrypt hashes from before argon2id start with $2a$, $2b$ or $2y$
+func isBcrypt(hash []byte) bool {
+	return strings.HasPrefix(string(hash), "$2")
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPassword(t *testing.T) {
+	//cheap parameters, the real ones make the test slow
+	params := Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	hasher := newPasswordHasher(params)
+
+	t.Run("argon2id round trip", func(t *testing.T) {
+		var p password
+		p.SetPlaintext("correct horse")
+		if err := hasher.hash(&p); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := hasher.compare(&p, "correct horse"); err != nil {
+			t.Errorf("expected a match, got %v", err)
+		}
+		if err := hasher.compare(&p, "wrong horse"); err != ErrPasswordMismatch {
+			t.Errorf("expected ErrPasswordMismatch, got %v", err)
+		}
+		if p.NeedsRehash() {
+			t.Error("fresh hash shouldn't need a rehash")
+		}
+	})
+
+	t.Run("legacy bcrypt hash still works and needs a rehash", func(t *testing.T) {
+		hash, err := bcrypt.GenerateFromPassword([]byte("legacy"), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := password{hash: hash}
+
+		if err := hasher.compare(&p, "legacy"); err != nil {
+			t.Errorf("expected a match, got %v", err)
+		}
+		if err := hasher.compare(&p, "other"); err != ErrPasswordMismatch {
+			t.Errorf("expected ErrPasswordMismatch, got %v", err)
+		}
+		if !p.NeedsRehash() {
+			t.Error("bcrypt hash should need a rehash")
+		}
+	})
+
+	t.Run("changed parameters need a rehash", func(t *testing.T) {
+		var p password
+		p.SetPlaintext("correct horse")
+		if err := hasher.hash(&p); err != nil {
+			t.Fatal(err)
+		}
+
+		changed := params
+		changed.Iterations++
+		other := newPasswordHasher(changed)
+
+		if err := other.compare(&p, "correct horse"); err != nil {
+			t.Errorf("old parameters should still verify, got %v", err)
+		}
+		if !p.NeedsRehash() {
+			t.Error("hash with old parameters should need a rehash")
+		}
+	})
+
+	t.Run("missing hash never matches", func(t *testing.T) {
+		var p password
+		if err := hasher.compare(&p, "gophersocial-dummy-password"); err != ErrPasswordMismatch {
+			t.Errorf("expected ErrPasswordMismatch, got %v", err)
+		}
+	})
+
+	t.Run("SetPlaintext keeps the plaintext until the store hashes it", func(t *testing.T) {
+		var p password
+		p.SetPlaintext("correct horse")
+		if len(p.hash) != 0 {
+			t.Error("expected no hash before the store wrote the password")
+		}
+		if err := hasher.hash(&p); err != nil {
+			t.Fatal(err)
+		}
+		if !isArgon2id(p.hash) {
+			t.Errorf("expected an argon2id hash, got %q", p.hash)
+		}
+		if p.text != nil {
+			t.Error("expected the plaintext to be dropped after hashing")
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// PostRevision is a post as it was at one version, written with every create, update and restore
+type PostRevision struct {
+	ID       int64    `json:"-"`
+	PostID   int64    `json:"post_id"`
+	Version  int      `json:"version"`
+	Title    string   `json:"title"`
+	Content  string   `json:"content"`
+	Tags     []string `json:"tags"`
+	EditorID int64    `json:"editor_id"`
+	//empty once the editor was deleted
+	EditorUsername string `json:"editor_username"`
+	RestoredFrom   *int   `json:"restored_from"`
+	//fields that differ from the previous revision, all of them for the first one
+	Changed   []string `json:"changed"`
+	CreatedAt string   `json:"created_at"`
+}
+
+type PostRevisionPage struct {
+	Revisions  []PostRevision `json:"revisions"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type PostRevisionStore struct {
+	db *sql.DB
+}
+
+// GetByPostID lists the revisions of a post newest first
+func (s *PostRevisionStore) GetByPostID(ctx context.Context, postID int64, q CursorQuery) (*PostRevisionPage, error) {
+	//the previous revision has to be looked at before the page is cut out
+	query := `
+		SELECT v.id, v.post_id, v.version, v.title, v.content, v.tags, COALESCE(v.editor_id, 0), COALESCE(u.username, ''),
+			v.restored_from, v.changed, v.created_at
+		FROM (
+			SELECT r.*, ARRAY_REMOVE(ARRAY[
+				CASE WHEN r.title IS DISTINCT FROM LAG(r.title) OVER w THEN 'title' END,
+				CASE WHEN r.content IS DISTINCT FROM LAG(r.content) OVER w THEN 'content' END,
+				CASE WHEN r.tags IS DISTINCT FROM LAG(r.tags) OVER w OR LAG(r.id) OVER w IS NULL THEN 'tags' END
+			], NULL) AS changed
+			FROM post_revisions r
+			WHERE r.post_id = $1
+			WINDOW w AS (ORDER BY r.version)
+		) v
+		LEFT JOIN users u ON u.id = v.editor_id
+		WHERE $2::bigint = 0 OR v.id < $2
+		ORDER BY v.id DESC
+		LIMIT $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, postID, q.Before, q.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []PostRevision{}
+	for rows.Next() {
+		var r PostRevision
+		var restoredFrom sql.NullInt32
+		err := rows.Scan(
+			&r.ID,
+			&r.PostID,
+			&r.Version,
+			&r.Title,
+			&r.Content,
+			pq.Array(&r.Tags),
+			&r.EditorID,
+			&r.EditorUsername,
+			&restoredFrom,
+			pq.Array(&r.Changed),
+			&r.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if restoredFrom.Valid {
+			v := int(restoredFrom.Int32)
+			r.RestoredFrom = &v
+		}
+		revisions = append(revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &PostRevisionPage{Revisions: revisions}
+	if len(revisions) > q.Limit {
+		page.Revisions = revisions[:q.Limit]
+		page.NextCursor = encodeCursor(page.Revisions[q.Limit-1].ID)
+	}
+
+	return page, nil
+}
+
+// createRevision records post as it is now, it runs in the transaction that changed the post
+func createRevision(ctx context.Context, tx *sql.Tx, post *Post, editorID int64, restoredFrom *int) error {
+	query := `
+		INSERT INTO post_revisions (post_id, version, title, content, tags, editor_id, restored_from)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err := tx.ExecContext(ctx, query, post.ID, post.Version, post.Title, post.Content, pq.Array(post.Tags), editorID, restoredFrom)
+	return err
+}
+
+// getRevision is used by PostStore.Restore
+func getRevision(ctx context.Context, tx *sql.Tx, postID int64, version int) (*PostRevision, error) {
+	query := `SELECT title, content, tags FROM post_revisions WHERE post_id = $1 AND version = $2`
+
+	r := &PostRevision{PostID: postID, Version: version}
+	err := tx.QueryRowContext(ctx, query, postID, version).Scan(&r.Title, &r.Content, pq.Array(&r.Tags))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
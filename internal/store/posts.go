@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/lib/pq"
 )
@@ -14,16 +15,30 @@ type PostStore struct {
 
 // ex 30 adding version field for optimistic concurrency control
 type Post struct {
-	ID        int64     `json:"id"`
-	Content   string    `json:"content"`
-	Title     string    `json:"Title"`
-	UserID    int64     `json:"user_id"`
-	Tags      []string  `json:"tags"`
-	CreatedAt string    `json:"created_at"`
-	UpdatedAt string    `json:"updated_at"`
-	Version   int       `json:"version"`
-	Comments  []Comment `json:"comments"`
-	User      User      `json:"user"`
+	ID        int64    `json:"id"`
+	Content   string   `json:"content"`
+	Title     string   `json:"Title"`
+	UserID    int64    `json:"user_id"`
+	Tags      []string `json:"tags"`
+	CreatedAt string   `json:"created_at"`
+	UpdatedAt string   `json:"updated_at"`
+	Version   int      `json:"version"`
+	Edited    bool     `json:"edited"`
+	//nil while the post is a draft, only the author can see drafts
+	PublishedAt *time.Time `json:"published_at"`
+	//when the publisher job publishes the draft, nil if it isn't scheduled
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	Draft     bool       `json:"draft"`
+	//images in upload order, see MediaStore
+	Media    []Media   `json:"media"`
+	Comments []Comment `json:"comments"`
+	User     User      `json:"user"`
+}
+
+// PostPage is a page of drafts, see CursorQuery
+type PostPage struct {
+	Posts      []Post `json:"posts"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 // excerise 37, using PostWithMetadata by struct composition or embedding struct post in it
@@ -31,33 +46,52 @@ type Post struct {
 type PostWithMetadata struct {
 	Post
 	CommentCount int `json:"comment_count"`
+	ReactionSummary
 }
 
+// postEdited tells whether the post was updated after it was published, edits of a draft don't count
+const postEdited = `COALESCE(version > published_version, false)`
+
+// publishVersion bumps the version on publishing and remembers it, see postEdited
+const publishVersion = `version = version + 1, published_version = version + 1`
+
+// Create publishes the post right away unless post.Draft is set, a draft with PublishAt is scheduled
 func (s *PostStore) Create(ctx context.Context, post *Post) error {
 
 	query := `
-	INSERT INTO posts (content, title, user_id, tags)
-	VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at
+	INSERT INTO posts (content, title, user_id, tags, published_at, published_version, publish_at)
+	VALUES ($1, $2, $3, $4, CASE WHEN $5::boolean THEN NULL ELSE NOW() END, CASE WHEN $5::boolean THEN NULL ELSE 0 END, $6)
+	RETURNING id, created_at, updated_at, version, published_at
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	err := s.db.QueryRowContext(ctx, query, post.Content, post.Title, post.UserID, pq.Array(post.Tags)).Scan(
-		&post.ID, &post.CreatedAt, &post.UpdatedAt,
-	)
-	if err != nil {
-		return err
-	}
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, post.Content, post.Title, post.UserID, pq.Array(post.Tags), post.Draft, post.PublishAt).Scan(
+			&post.ID, &post.CreatedAt, &post.UpdatedAt, &post.Version, &post.PublishedAt,
+		)
+		if err != nil {
+			return err
+		}
+		post.Draft = post.PublishedAt == nil
 
-	return nil
+		if err := attachMedia(ctx, tx, post); err != nil {
+			return err
+		}
+
+		//the first revision, so the history shows what the post looked like before any edit
+		return createRevision(ctx, tx, post, post.UserID, nil)
+	})
 }
 
 func (s *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
 	query := `
-		SELECT id, user_id, title, content, created_at,  updated_at, tags, version
+		SELECT id, user_id, title, content, created_at,  updated_at, tags, version, published_at, publish_at,
+			` + postEdited + `
 		FROM posts
-		WHERE id = $1
+		WHERE id = $1 AND deleted_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM users u WHERE u.id = posts.user_id AND u.deleted_at IS NOT NULL)
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
@@ -65,7 +99,7 @@ func (s *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
 
 	var post Post
 	err := s.db.QueryRowContext(ctx, query, id).Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt, &post.UpdatedAt,
-		pq.Array(&post.Tags), &post.Version,
+		pq.Array(&post.Tags), &post.Version, &post.PublishedAt, &post.PublishAt, &post.Edited,
 	)
 	if err != nil {
 		switch {
@@ -75,73 +109,284 @@ func (s *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
 			return nil, err
 		}
 	}
+	post.Draft = post.PublishedAt == nil
+
+	media, err := mediaByPostID(ctx, s.db, []int64{post.ID})
+	if err != nil {
+		return nil, err
+	}
+	post.Media = media[post.ID]
 
 	return &post, nil
 }
 
-func (s *PostStore) Delete(ctx context.Context, postID int64) error {
-	query := `DELETE FROM posts WHERE id = $1`
+// GetDrafts lists the drafts of a user newest first
+func (s *PostStore) GetDrafts(ctx context.Context, userID int64, q CursorQuery) (*PostPage, error) {
+	query := `
+		SELECT id, user_id, title, content, created_at, updated_at, tags, version, publish_at
+		FROM posts
+		WHERE user_id = $1 AND published_at IS NULL AND deleted_at IS NULL
+		AND ($2::bigint = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3
+	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	res, err := s.db.ExecContext(ctx, query, postID)
+	rows, err := s.db.QueryContext(ctx, query, userID, q.Before, q.Limit+1)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var post Post
+		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt, &post.UpdatedAt,
+			pq.Array(&post.Tags), &post.Version, &post.PublishAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		post.Draft = true
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &PostPage{Posts: posts}
+	if len(posts) > q.Limit {
+		page.Posts = posts[:q.Limit]
+		page.NextCursor = encodeCursor(page.Posts[q.Limit-1].ID)
 	}
 
-	rows, err := res.RowsAffected()
+	ids := make([]int64, len(page.Posts))
+	for i, post := range page.Posts {
+		ids[i] = post.ID
+	}
+	media, err := mediaByPostID(ctx, s.db, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range page.Posts {
+		page.Posts[i].Media = media[page.Posts[i].ID]
+	}
+
+	return page, nil
+}
+
+// Schedule sets when the publisher job publishes a draft, nil unschedules it. ErrNotFound means there is
+// no such draft, it might have been published meanwhile
+func (s *PostStore) Schedule(ctx context.Context, postID int64, publishAt *time.Time) error {
+	query := `UPDATE posts SET publish_at = $2 WHERE id = $1 AND published_at IS NULL AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, postID, publishAt)
+}
+
+// Publish publishes a draft now, ErrNotFound if it isn't one. Publishing bumps the version like an update,
+// copies of the draft are outdated
+func (s *PostStore) Publish(ctx context.Context, postID int64) error {
+	query := `
+		UPDATE posts SET published_at = NOW(), publish_at = NULL, ` + publishVersion + `
+		WHERE id = $1 AND published_at IS NULL AND deleted_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, postID)
+}
+
+// PublishDue publishes the scheduled drafts that are due. They are published as of publish_at, so the
+// feed shows them where they were planned even when the job comes by a little later
+func (s *PostStore) PublishDue(ctx context.Context) (int64, error) {
+	query := `
+		UPDATE posts SET published_at = publish_at, publish_at = NULL, ` + publishVersion + `
+		WHERE published_at IS NULL AND publish_at <= NOW() AND deleted_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Delete soft deletes the post if it is still at version, ErrEditConflict otherwise. The retention job
+// purges it for good
+func (s *PostStore) Delete(ctx context.Context, postID int64, version int) error {
+	query := `UPDATE posts SET deleted_at = NOW() WHERE id = $1 AND version = $2 AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, postID, version)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return versionMismatch(ctx, tx, postID)
+		}
+
+		return nil
+	})
+}
+
+// Undelete brings back a soft deleted post, until the retention job purged it
+func (s *PostStore) Undelete(ctx context.Context, postID int64) error {
+	query := `UPDATE posts SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, postID)
+}
+
+// PurgeDeleted hard deletes posts deleted before deletedBefore, with everything hanging off them
+func (s *PostStore) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	query := `SELECT id FROM posts WHERE deleted_at < $1 FOR UPDATE`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var purged int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		var ids []int64
+		if err := tx.QueryRowContext(ctx, `SELECT ARRAY(`+query+`)`, deletedBefore).Scan(pq.Array(&ids)); err != nil {
+			return err
+		}
+
+		purged = int64(len(ids))
+		return purgePosts(ctx, tx, ids)
+	})
+
+	return purged, err
+}
+
+// purgePosts hard deletes soft deleted posts. Comments have no foreign key on posts, so they go first
+// (reactions and revisions cascade)
+func purgePosts(ctx context.Context, tx *sql.Tx, postIDs []int64) error {
+	if len(postIDs) == 0 {
+		return nil
+	}
+
+	queries := []string{
+		`UPDATE comments SET deleted_at = COALESCE(deleted_at, NOW()) WHERE post_id = ANY($1)`,
+		`DELETE FROM comments WHERE post_id = ANY($1)`,
+		`DELETE FROM posts WHERE id = ANY($1)`,
+	}
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, pq.Array(postIDs)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// versionMismatch tells why a write guarded by the version column didn't find the post
+func versionMismatch(ctx context.Context, tx *sql.Tx, postID int64) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1 AND deleted_at IS NULL)`, postID).Scan(&exists)
 	if err != nil {
 		return err
 	}
 
-	if rows == 0 {
+	if !exists {
 		return ErrNotFound
 	}
 
-	return nil
+	return ErrEditConflict
 }
 
-func (s *PostStore) Update(ctx context.Context, post *Post) error {
-	query := `
-		UPDATE posts
-		SET title = $1, content = $2, version = version + 1
-		WHERE id = $3 AND version = $4
-		RETURNING version
-	`
+// Update saves title, content and tags and records them as a revision of editorID. post.Version has
+// to be the current one, ErrEditConflict means somebody else updated the post since it was read
+func (s *PostStore) Update(ctx context.Context, post *Post, editorID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		return s.update(ctx, tx, post, editorID, nil)
+	})
+}
 
+// Restore puts the post back the way it was at version, as a new revision. Like Update it fails with
+// ErrEditConflict when post.Version is outdated, ErrNotFound means there is no such revision
+func (s *PostStore) Restore(ctx context.Context, post *Post, version int, editorID int64) error {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	err := s.db.QueryRowContext(ctx, query, post.Title, post.Content, post.ID, post.Version).Scan(&post.Version)
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		revision, err := getRevision(ctx, tx, post.ID, version)
+		if err != nil {
+			return err
+		}
+
+		post.Title = revision.Title
+		post.Content = revision.Content
+		post.Tags = revision.Tags
+
+		return s.update(ctx, tx, post, editorID, &version)
+	})
+}
+
+func (s *PostStore) update(ctx context.Context, tx *sql.Tx, post *Post, editorID int64, restoredFrom *int) error {
+	query := `
+		UPDATE posts
+		SET title = $1, content = $2, tags = $3, version = version + 1, updated_at = NOW()
+		WHERE id = $4 AND version = $5 AND deleted_at IS NULL
+		RETURNING version, updated_at, ` + postEdited + `
+	`
+
+	err := tx.QueryRowContext(ctx, query, post.Title, post.Content, pq.Array(post.Tags), post.ID, post.Version).Scan(
+		&post.Version, &post.UpdatedAt, &post.Edited,
+	)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrNotFound
+			return versionMismatch(ctx, tx, post.ID)
 		default:
 			return err
 		}
 	}
 
-	return nil
+	return createRevision(ctx, tx, post, editorID, restoredFrom)
 }
 
 func (s *PostStore) GetUserFeed(ctx context.Context, userID int64, fq PaginatedFeedQuery) ([]PostWithMetadata, error) {
 	query := `
 	SELECT 
-			p.id, p.user_id, p.title, p.content, p.created_at, p.version, p.tags,
+			p.id, p.user_id, p.title, p.content, p.created_at, p.published_at, p.version, p.tags,
+			p.version > p.published_version,
 			u.username,
-			COUNT(c.id) AS comments_count
+			(` + commentCountQuery("p.id") + `) AS comments_count,
+			` + reactionSummaryColumns("$1") + `
 		FROM posts p
-		LEFT JOIN comments c ON c.post_id = p.id
 		LEFT JOIN users u ON p.user_id = u.id
 		JOIN followers f ON f.follower_id = p.user_id OR p.user_id = $1
 		WHERE 
 			f.user_id = $1 AND
+			p.published_at IS NOT NULL AND p.deleted_at IS NULL AND u.deleted_at IS NULL AND
 			(p.title ILIKE '%' || $4 || '%' OR p.content ILIKE '%' || $4 || '%') AND
 			(p.tags @> $5 OR $5 = '{}')
 		GROUP BY p.id, u.username
-		ORDER BY p.created_at ` + fq.Sort + `
+		ORDER BY p.published_at ` + fq.Sort + `
 		LIMIT $2 OFFSET $3
 		`
 
@@ -159,12 +404,28 @@ func (s *PostStore) GetUserFeed(ctx context.Context, userID int64, fq PaginatedF
 
 	for rows.Next() {
 		var post PostWithMetadata
-		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt, &post.Version, pq.Array(&post.Tags), &post.User.Username, &post.CommentCount)
+		dest := []any{&post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt, &post.PublishedAt, &post.Version, pq.Array(&post.Tags), &post.Edited, &post.User.Username, &post.CommentCount}
+		err := rows.Scan(append(dest, post.scanTargets()...)...)
 		if err != nil {
 			return nil, err
 		}
-
 		feed = append(feed, post)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(feed))
+	for i, post := range feed {
+		ids[i] = post.ID
+	}
+	media, err := mediaByPostID(ctx, s.db, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range feed {
+		feed[i].Media = media[feed[i].ID]
+	}
+
 	return feed, nil
 }
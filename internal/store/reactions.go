@@ -0,0 +1,163 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// ReactionSummary is embedded in posts, counts are kept up to date by a trigger so they are cheap to read
+type ReactionSummary struct {
+	//per kind, kinds nobody used are left out
+	Reactions map[string]int `json:"reactions"`
+	//kinds the authenticated user reacted with
+	MyReactions []string `json:"my_reactions"`
+}
+
+type Reaction struct {
+	ID        int64  `json:"-"`
+	PostID    int64  `json:"post_id"`
+	UserID    int64  `json:"user_id"`
+	Kind      string `json:"kind"`
+	CreatedAt string `json:"created_at"`
+	User      User   `json:"user"`
+}
+
+type ReactionPage struct {
+	Reactions  []Reaction `json:"reactions"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// ReactionQuery lists who reacted to a post, optionally with one kind only
+type ReactionQuery struct {
+	CursorQuery
+	Kind string `json:"kind" validate:"max=32"`
+}
+
+func (q ReactionQuery) Parse(r *http.Request) (ReactionQuery, error) {
+	q.Kind = r.URL.Query().Get("kind")
+
+	var err error
+	q.CursorQuery, err = q.CursorQuery.Parse(r)
+	return q, err
+}
+
+// reactionSummaryColumns reads the ReactionSummary of p for the user in the given placeholder
+func reactionSummaryColumns(userParam string) string {
+	return `
+		COALESCE((SELECT jsonb_object_agg(rc.kind, rc.count) FROM post_reaction_counts rc WHERE rc.post_id = p.id AND rc.count > 0), '{}'),
+		ARRAY(SELECT pr.kind FROM post_reactions pr WHERE pr.post_id = p.id AND pr.user_id = ` + userParam + ` ORDER BY pr.kind)
+	`
+}
+
+// reactionCounts reads the jsonb object built by reactionSummaryColumns
+type reactionCounts map[string]int
+
+func (c *reactionCounts) Scan(src any) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("reaction counts: unexpected %T", src)
+	}
+	return json.Unmarshal(b, c)
+}
+
+// scanTargets are what to pass to Scan for reactionSummaryColumns
+func (rs *ReactionSummary) scanTargets() []any {
+	return []any{(*reactionCounts)(&rs.Reactions), pq.Array(&rs.MyReactions)}
+}
+
+type ReactionStore struct {
+	db *sql.DB
+}
+
+// React is idempotent, reacting twice with the same kind counts once
+func (s *ReactionStore) React(ctx context.Context, postID, userID int64, kind string) error {
+	query := `
+		INSERT INTO post_reactions (post_id, user_id, kind)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (post_id, user_id, kind) DO NOTHING
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, postID, userID, kind)
+	return err
+}
+
+// Unreact is idempotent as well, there is no error for a reaction that isn't there
+func (s *ReactionStore) Unreact(ctx context.Context, postID, userID int64, kind string) error {
+	query := `DELETE FROM post_reactions WHERE post_id = $1 AND user_id = $2 AND kind = $3`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, postID, userID, kind)
+	return err
+}
+
+func (s *ReactionStore) GetSummary(ctx context.Context, postID, userID int64) (*ReactionSummary, error) {
+	query := `SELECT ` + reactionSummaryColumns("$2") + ` FROM posts p WHERE p.id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	summary := &ReactionSummary{}
+	err := s.db.QueryRowContext(ctx, query, postID, userID).Scan(summary.scanTargets()...)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return summary, nil
+}
+
+// GetByPostID lists who reacted to a post, newest first
+func (s *ReactionStore) GetByPostID(ctx context.Context, postID int64, q ReactionQuery) (*ReactionPage, error) {
+	query := `
+		SELECT pr.id, pr.post_id, pr.user_id, pr.kind, pr.created_at, u.username
+		FROM post_reactions pr
+		JOIN users u ON u.id = pr.user_id
+		WHERE pr.post_id = $1 AND ($2 = '' OR pr.kind = $2) AND ($3::bigint = 0 OR pr.id < $3) AND u.deleted_at IS NULL
+		ORDER BY pr.id DESC
+		LIMIT $4
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, postID, q.Kind, q.Before, q.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reactions := []Reaction{}
+	for rows.Next() {
+		var r Reaction
+		if err := rows.Scan(&r.ID, &r.PostID, &r.UserID, &r.Kind, &r.CreatedAt, &r.User.Username); err != nil {
+			return nil, err
+		}
+		r.User.ID = r.UserID
+		reactions = append(reactions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &ReactionPage{Reactions: reactions}
+	if len(reactions) > q.Limit {
+		page.Reactions = reactions[:q.Limit]
+		page.NextCursor = encodeCursor(page.Reactions[q.Limit-1].ID)
+	}
+
+	return page, nil
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrTokenReused = errors.New("refresh token has already been used")
+
+type RefreshToken struct {
+	ID        int64
+	UserID    int64
+	FamilyID  string
+	Expiry    time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+type RefreshTokenStore struct {
+	db *sql.DB
+}
+
+// Create stores the hash of a plaintext refresh token, the plaintext is only ever known by the client
+func (s *RefreshTokenStore) Create(ctx context.Context, token string, userID int64, familyID string, exp time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return insertRefreshToken(ctx, s.db, token, userID, familyID, exp)
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// insertRefreshToken stores the hash of token, db is the pool or the transaction it has to be part of
+func insertRefreshToken(ctx context.Context, db execer, token string, userID int64, familyID string, exp time.Duration) error {
+	query := `INSERT INTO refresh_tokens (token, user_id, family_id, expiry) VALUES ($1, $2, $3, $4)`
+
+	_, err := db.ExecContext(ctx, query, hashToken(token), userID, familyID, time.Now().Add(exp))
+	return err
+}
+
+// Rotate marks the presented token as used and stores newToken in the same family.
+// Presenting a token which was already used revokes the whole family and returns ErrTokenReused, together
+// with the token so the caller knows the family
+func (s *RefreshTokenStore) Rotate(ctx context.Context, token, newToken string, exp time.Duration) (*RefreshToken, error) {
+	current := &RefreshToken{}
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+		SELECT id, user_id, family_id, expiry, used_at, revoked_at, created_at
+		FROM refresh_tokens
+		WHERE token = $1
+		FOR UPDATE
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(ctx, query, hashToken(token)).Scan(
+			&current.ID,
+			&current.UserID,
+			&current.FamilyID,
+			&current.Expiry,
+			&current.UsedAt,
+			&current.RevokedAt,
+			&current.CreatedAt,
+		)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if err := current.checkRotatable(time.Now()); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1`, current.ID)
+		if err != nil {
+			return err
+		}
+
+		return insertRefreshToken(ctx, tx, newToken, current.UserID, current.FamilyID, exp)
+	})
+	if err != nil {
+		//the revocation has to outlive the rolled back transaction, so it runs on its own
+		if errors.Is(err, ErrTokenReused) {
+			if revokeErr := s.RevokeFamily(ctx, current.FamilyID); revokeErr != nil {
+				return nil, revokeErr
+			}
+			return current, err
+		}
+		return nil, err
+	}
+
+	return current, nil
+}
+
+// checkRotatable tells whether the token may be exchanged for a new one. Revoked and expired tokens are
+// as good as unknown, a used one means it was copied
+func (t *RefreshToken) checkRotatable(now time.Time) error {
+	if t.RevokedAt != nil || t.Expiry.Before(now) {
+		return ErrNotFound
+	}
+
+	if t.UsedAt != nil {
+		return ErrTokenReused
+	}
+
+	return nil
+}
+
+// RevokeFamily revokes every refresh token issued from the same login
+func (s *RefreshTokenStore) RevokeFamily(ctx context.Context, familyID string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, familyID)
+	return err
+}
+
+// RevokeAllForUser revokes every refresh token family of the user, e.g. after a password change
+func (s *RefreshTokenStore) RevokeAllForUser(ctx context.Context, userID int64) error {
+	query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID)
+	return err
+}
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenRotatable(t *testing.T) {
+	now := time.Now()
+	earlier := now.Add(-time.Minute)
+
+	tests := []struct {
+		name  string
+		token RefreshToken
+		err   error
+	}{
+		{"fresh", RefreshToken{Expiry: now.Add(time.Hour)}, nil},
+		{"expired", RefreshToken{Expiry: earlier}, ErrNotFound},
+		{"revoked", RefreshToken{Expiry: now.Add(time.Hour), RevokedAt: &earlier}, ErrNotFound},
+		{"used", RefreshToken{Expiry: now.Add(time.Hour), UsedAt: &earlier}, ErrTokenReused},
+		//a replayed token of a family that is revoked already must not revoke anything again
+		{"used and revoked", RefreshToken{Expiry: now.Add(time.Hour), UsedAt: &earlier, RevokedAt: &earlier}, ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		if err := tt.token.checkRotatable(now); err != tt.err {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RevokedTokenStore is the Postgres denylist for access tokens, the Redis one in the cache package is
+// preferred and this is only used when Redis is disabled
+type RevokedTokenStore struct {
+	db *sql.DB
+}
+
+// Revoke denies the access token with this jti until exp, after that the token is dead anyway
+// and PurgeExpired removes the row
+func (s *RevokedTokenStore) Revoke(ctx context.Context, jti string, exp time.Time) error {
+	query := `INSERT INTO revoked_tokens (jti, expiry) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, jti, exp)
+	return err
+}
+
+// PurgeExpired removes the entries of tokens which expired by now, run by the purge-revoked-tokens job
+func (s *RevokedTokenStore) PurgeExpired(ctx context.Context) (int64, error) {
+	query := `DELETE FROM revoked_tokens WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (s *RevokedTokenStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1 AND expiry > NOW())`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, query, jti).Scan(&revoked)
+	if err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+// RevokeIssuedBefore denies every access token of the user issued before t. ttl is only a hint for
+// stores which expire keys, the column simply stays
+func (s *RevokedTokenStore) RevokeIssuedBefore(ctx context.Context, userID int64, t time.Time, ttl time.Duration) error {
+	query := `UPDATE users SET tokens_revoked_before = $2 WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID, t)
+	return err
+}
+
+// IssuedBefore returns the watermark set by RevokeIssuedBefore, the zero time if there is none
+func (s *RevokedTokenStore) IssuedBefore(ctx context.Context, userID int64) (time.Time, error) {
+	query := `SELECT tokens_revoked_before FROM users WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var before sql.NullTime
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&before)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return time.Time{}, ErrNotFound
+		default:
+			return time.Time{}, err
+		}
+	}
+
+	return before.Time, nil
+}
+
+// RevokeFamily has nothing to write, revoking the family in refresh_tokens is what IsFamilyRevoked reads
+func (s *RevokedTokenStore) RevokeFamily(ctx context.Context, familyID string, ttl time.Duration) error {
+	return nil
+}
+
+// IsFamilyRevoked tells whether the refresh token family in the sid claim was revoked
+func (s *RevokedTokenStore) IsFamilyRevoked(ctx context.Context, familyID string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM refresh_tokens WHERE family_id = $1 AND revoked_at IS NOT NULL)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, query, familyID).Scan(&revoked)
+	if err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
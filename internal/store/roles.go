@@ -3,6 +3,25 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var ErrUnknownPermission = errors.New("unknown permission")
+
+// permissions seeded by migrations 000021 and later, roles get them through role_permissions
+const (
+	PermPostsCreate       = "posts.create"
+	PermPostsUpdateAny    = "posts.update.any"
+	PermPostsDeleteAny    = "posts.delete.any"
+	PermCommentsCreate    = "comments.create"
+	PermCommentsUpdateAny = "comments.update.any"
+	PermCommentsDeleteAny = "comments.delete.any"
+	PermMFARequired       = "auth.mfa.required"
+	PermRolesManage       = "roles.manage"
+	PermAuditRead         = "audit.read"
+	PermContentRestore    = "content.restore"
 )
 
 type Role struct {
@@ -10,6 +29,8 @@ type Role struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Level       int    `json:"level"`
+	//only filled by the admin API, users carry their role without it
+	Permissions []string `json:"permissions,omitempty"`
 }
 
 type RoleStore struct {
@@ -28,3 +49,240 @@ func (s *RoleStore) GetByName(ctx context.Context, slug string) (*Role, error) {
 
 	return role, nil
 }
+
+// GetPermissions returns the names of all permissions granted to the role
+func (s *RoleStore) GetPermissions(ctx context.Context, roleID int64) ([]string, error) {
+	query := `
+	SELECT p.name FROM permissions p
+	JOIN role_permissions rp ON (rp.permission_id = p.id)
+	WHERE rp.role_id = $1
+	ORDER BY p.name
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, name)
+	}
+
+	return permissions, rows.Err()
+}
+
+// GetAll returns every role with its permissions, for the admin API
+func (s *RoleStore) GetAll(ctx context.Context) ([]Role, error) {
+	query := `
+	SELECT r.id, r.name, COALESCE(r.description, ''), r.level,
+		COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+	FROM roles r
+	LEFT JOIN role_permissions rp ON (rp.role_id = r.id)
+	LEFT JOIN permissions p ON (p.id = rp.permission_id)
+	GROUP BY r.id
+	ORDER BY r.level, r.id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.Level, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+func (s *RoleStore) GetByID(ctx context.Context, roleID int64) (*Role, error) {
+	query := `SELECT id, name, COALESCE(description, ''), level FROM roles WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	role := &Role{}
+	err := s.db.QueryRowContext(ctx, query, roleID).Scan(&role.ID, &role.Name, &role.Description, &role.Level)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	role.Permissions, err = s.GetPermissions(ctx, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// Create stores a new role with its permissions
+func (s *RoleStore) Create(ctx context.Context, role *Role) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `INSERT INTO roles (name, description, level) VALUES ($1, $2, $3) RETURNING id`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(ctx, query, role.Name, role.Description, role.Level).Scan(&role.ID)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+				return ErrConflict
+			default:
+				return err
+			}
+		}
+
+		return setRolePermissions(ctx, tx, role.ID, role.Permissions)
+	})
+}
+
+// Update replaces name, description, level and permissions of the role
+func (s *RoleStore) Update(ctx context.Context, role *Role) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE roles SET name = $1, description = $2, level = $3 WHERE id = $4`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, role.Name, role.Description, role.Level, role.ID)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+				return ErrConflict
+			default:
+				return err
+			}
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrNotFound
+		}
+
+		return setRolePermissions(ctx, tx, role.ID, role.Permissions)
+	})
+}
+
+// AssignToUser changes the role of the user and returns the role it had before, ErrNotFound if either
+// of them doesn't exist
+func (s *RoleStore) AssignToUser(ctx context.Context, userID, roleID int64) (int64, error) {
+	var previousRoleID int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(ctx, `SELECT role_id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&previousRoleID)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE users SET role_id = $1 WHERE id = $2`, roleID, userID)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: insert or update on table "users" violates foreign key constraint "users_role_id_fkey"`:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return previousRoleID, err
+}
+
+// GetUserIDs returns the users holding the role, their cached copies carry it
+func (s *RoleStore) GetUserIDs(ctx context.Context, roleID int64) ([]int64, error) {
+	query := `SELECT id FROM users WHERE role_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// setRolePermissions replaces the permissions of the role, ErrUnknownPermission if a name doesn't exist
+func setRolePermissions(ctx context.Context, tx *sql.Tx, roleID int64, permissions []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role_id = $1`, roleID)
+	if err != nil {
+		return err
+	}
+
+	if len(permissions) == 0 {
+		return nil
+	}
+
+	query := `
+	INSERT INTO role_permissions (role_id, permission_id)
+	SELECT $1, id FROM permissions WHERE name = ANY($2)
+	`
+	res, err := tx.ExecContext(ctx, query, roleID, pq.Array(permissions))
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	//duplicates in permissions are inserted once, so compare against the distinct names
+	distinct := map[string]bool{}
+	for _, p := range permissions {
+		distinct[p] = true
+	}
+	if int(rows) != len(distinct) {
+		return ErrUnknownPermission
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// encrypted values are prefixed, so secrets stored in plaintext before encryption can still be read
+const sealedPrefix = "v1:"
+
+var errSealedSecret = errors.New("secret can't be decrypted")
+
+// secretBox encrypts secrets we have to read back later, like TOTP seeds, with AES-256-GCM.
+// A hash only works for things we compare against, the TOTP seed is needed to compute codes
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+// newSecretBox derives the AES key from the configured secret, any string gives a valid key
+func newSecretBox(secret string) *secretBox {
+	key := sha256.Sum256([]byte(secret))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		//only happens for a key length other than 16, 24 or 32
+		panic(err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	return &secretBox{aead: aead}
+}
+
+func (b *secretBox) seal(plaintext string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (b *secretBox) open(stored string) (string, error) {
+	if !strings.HasPrefix(stored, sealedPrefix) {
+		return stored, nil
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", errSealedSecret
+	}
+
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		//a different key than the one it was sealed with, or the value was tampered with
+		return "", errSealedSecret
+	}
+
+	return string(plaintext), nil
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSecretBox(t *testing.T) {
+	box := newSecretBox("test-key")
+
+	t.Run("should round trip a secret", func(t *testing.T) {
+		sealed, err := box.seal("JBSWY3DPEHPK3PXP")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(sealed, "JBSWY3DPEHPK3PXP") {
+			t.Fatal("sealed value contains the plaintext")
+		}
+
+		other, _ := box.seal("JBSWY3DPEHPK3PXP")
+		if other == sealed {
+			t.Error("expected a fresh nonce for every seal")
+		}
+
+		plaintext, err := box.open(sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plaintext != "JBSWY3DPEHPK3PXP" {
+			t.Errorf("expected JBSWY3DPEHPK3PXP, got %s", plaintext)
+		}
+	})
+
+	t.Run("should reject another key or a tampered value", func(t *testing.T) {
+		sealed, err := box.seal("JBSWY3DPEHPK3PXP")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := newSecretBox("other-key").open(sealed); err != errSealedSecret {
+			t.Errorf("expected %v, got %v", errSealedSecret, err)
+		}
+
+		raw, _ := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+		raw[len(raw)-1] ^= 1
+		tampered := sealedPrefix + base64.RawStdEncoding.EncodeToString(raw)
+		if _, err := box.open(tampered); err != errSealedSecret {
+			t.Errorf("expected %v, got %v", errSealedSecret, err)
+		}
+	})
+
+	t.Run("should read a secret stored before encryption", func(t *testing.T) {
+		plaintext, err := box.open("JBSWY3DPEHPK3PXP")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plaintext != "JBSWY3DPEHPK3PXP" {
+			t.Errorf("expected JBSWY3DPEHPK3PXP, got %s", plaintext)
+		}
+	})
+}
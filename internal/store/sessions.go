@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Session is one login of a user, ID is the refresh token family and the sid claim of its access tokens
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int64     `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	//set by the API for the session making the request
+	Current bool `json:"current"`
+}
+
+// a family is live while its newest refresh token can still be used
+const liveFamilyCondition = `
+EXISTS (
+	SELECT 1 FROM refresh_tokens rt
+	WHERE rt.family_id = s.id AND rt.used_at IS NULL AND rt.revoked_at IS NULL AND rt.expiry > NOW()
+)`
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+// Create stores the session together with the first refresh token of its family, a session without
+// a token would never be live and one can't exist without the other
+func (s *SessionStore) Create(ctx context.Context, session *Session, refreshToken string, exp time.Duration) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+		INSERT INTO sessions (id, user_id, user_agent, ip) VALUES ($1, $2, $3, $4)
+		RETURNING created_at, last_seen_at
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(ctx, query, session.ID, session.UserID, session.UserAgent, session.IP).Scan(
+			&session.CreatedAt,
+			&session.LastSeenAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		return insertRefreshToken(ctx, tx, refreshToken, session.UserID, session.ID, exp)
+	})
+}
+
+// GetActiveByUserID lists the sessions which can still be used, most recently seen first
+func (s *SessionStore) GetActiveByUserID(ctx context.Context, userID int64) ([]Session, error) {
+	query := `
+	SELECT s.id, s.user_id, s.user_agent, s.ip, s.created_at, s.last_seen_at
+	FROM sessions s
+	WHERE s.user_id = $1 AND ` + liveFamilyCondition + `
+	ORDER BY s.last_seen_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Revoke ends a session of the user by revoking its refresh token family, which AuthTokenMiddleware also
+// checks for access tokens. ErrNotFound if the session isn't the user's or already ended
+func (s *SessionStore) Revoke(ctx context.Context, sessionID string, userID int64) error {
+	query := `
+	UPDATE refresh_tokens SET revoked_at = NOW()
+	WHERE family_id = $1 AND revoked_at IS NULL
+		AND EXISTS (SELECT 1 FROM sessions s WHERE s.id = $1 AND s.user_id = $2 AND ` + liveFamilyCondition + `)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, sessionID, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Touch moves last_seen_at forward for a batch of sessions in a single query
+func (s *SessionStore) Touch(ctx context.Context, seen map[string]time.Time) error {
+	if len(seen) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(seen))
+	times := make([]int64, 0, len(seen))
+	for id, t := range seen {
+		ids = append(ids, id)
+		times = append(times, t.Unix())
+	}
+
+	query := `
+	UPDATE sessions s SET last_seen_at = to_timestamp(v.seen)
+	FROM unnest($1::uuid[], $2::bigint[]) AS v(id, seen)
+	WHERE s.id = v.id AND s.last_seen_at < to_timestamp(v.seen)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, pq.Array(ids), pq.Array(times))
+	return err
+}
+
+// PurgeEnded deletes sessions which can't be used anymore and weren't seen since seenBefore
+func (s *SessionStore) PurgeEnded(ctx context.Context, seenBefore time.Time) (int64, error) {
+	query := `DELETE FROM sessions s WHERE s.last_seen_at < $1 AND NOT ` + liveFamilyCondition
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, seenBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
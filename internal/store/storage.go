@@ -2,14 +2,18 @@ package store
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"time"
 )
 
 var (
-	ErrNotFound          = errors.New("resource not found")
-	ErrConflict          = errors.New("resource already exists")
+	ErrNotFound = errors.New("resource not found")
+	ErrConflict = errors.New("resource already exists")
+	//the row changed since it was read, see the version column of posts
+	ErrEditConflict      = errors.New("edit conflict")
 	QueryTimeoutDuration = time.Second * 5
 )
 
@@ -17,21 +21,67 @@ type Storage struct {
 	Posts interface {
 		GetByID(context.Context, int64) (*Post, error)
 		Create(context.Context, *Post) error
-		Delete(context.Context, int64) error
-		Update(context.Context, *Post) error
+		Delete(ctx context.Context, postID int64, version int) error
+		Update(ctx context.Context, post *Post, editorID int64) error
+		Restore(ctx context.Context, post *Post, version int, editorID int64) error
+		Undelete(context.Context, int64) error
+		PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error)
+		GetDrafts(ctx context.Context, userID int64, q CursorQuery) (*PostPage, error)
+		Schedule(ctx context.Context, postID int64, publishAt *time.Time) error
+		Publish(context.Context, int64) error
+		PublishDue(context.Context) (int64, error)
 		GetUserFeed(context.Context, int64, PaginatedFeedQuery) ([]PostWithMetadata, error)
 	}
 	Users interface {
 		GetByID(context.Context, int64) (*User, error)
 		GetByEmail(context.Context, string) (*User, error) //ex 51 Generating tokens
+		ComparePassword(user *User, text string) error
 		Create(context.Context, *sql.Tx, *User) error
 		CreateAndInvite(ctx context.Context, user *User, token string, exp time.Duration) error //ex 43 - Create use on user table and create user and token on user_invitation table
-		Activate(context.Context, string) error
+		Activate(context.Context, string) (int64, error)
 		Delete(context.Context, int64) error //ex 46
+		Undelete(context.Context, int64) error
+		PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error)
+		RecordFailedLogin(ctx context.Context, userID int64, maxAttempts int, lockout time.Duration) (bool, error)
+		ResetFailedLogins(context.Context, int64) error
+		CreateToken(ctx context.Context, userID int64, scope, token string, exp time.Duration) error
+		Unlock(context.Context, string) (int64, error)
+		RedeemMagicLink(context.Context, string) (int64, error)
+		ResetPassword(ctx context.Context, token string, user *User) error
+		GetByIdentity(ctx context.Context, provider, subject string) (*User, error)
+		LinkIdentity(context.Context, *Identity) (*User, error)
+		CreateWithIdentity(ctx context.Context, user *User, identity *Identity, token string, exp time.Duration) error
+		RotateInvitation(ctx context.Context, email, token string, exp time.Duration) (*User, error)
+		PurgeExpiredInvitations(context.Context) (int64, error)
+		PurgeUnactivated(ctx context.Context, createdBefore time.Time) (int64, error)
+		RequestEmailChange(ctx context.Context, userID int64, newEmail, token string, exp time.Duration) error
+		ConfirmEmailChange(context.Context, string) (*EmailChange, error)
+		UpdatePassword(context.Context, *User) error
 	}
 	Comments interface {
 		Create(context.Context, *Comment) error
-		GetByPostID(context.Context, int64) ([]Comment, error)
+		GetByPostID(ctx context.Context, postID int64, q CursorQuery) (*CommentPage, error)
+		CountByPostID(context.Context, int64) (int, error)
+		GetByID(context.Context, int64) (*Comment, error)
+		GetThread(ctx context.Context, comment *Comment, q CommentThreadQuery) error
+		Update(context.Context, *Comment) error
+		Delete(context.Context, int64) error
+		Undelete(context.Context, int64) error
+		PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error)
+	}
+	Media interface {
+		Create(context.Context, *Media) error
+		GetByID(context.Context, int64) (*Media, error)
+		DeleteOrphaned(ctx context.Context, createdBefore time.Time) ([]Media, error)
+	}
+	PostRevisions interface {
+		GetByPostID(ctx context.Context, postID int64, q CursorQuery) (*PostRevisionPage, error)
+	}
+	Reactions interface {
+		React(ctx context.Context, postID, userID int64, kind string) error
+		Unreact(ctx context.Context, postID, userID int64, kind string) error
+		GetSummary(ctx context.Context, postID, userID int64) (*ReactionSummary, error)
+		GetByPostID(ctx context.Context, postID int64, q ReactionQuery) (*ReactionPage, error)
 	}
 	Followers interface {
 		Follow(ctx context.Context, followerID, userID int64) error
@@ -39,16 +89,76 @@ type Storage struct {
 	}
 	Roles interface {
 		GetByName(context.Context, string) (*Role, error)
+		GetPermissions(context.Context, int64) ([]string, error)
+		GetAll(context.Context) ([]Role, error)
+		GetByID(context.Context, int64) (*Role, error)
+		Create(context.Context, *Role) error
+		Update(context.Context, *Role) error
+		AssignToUser(ctx context.Context, userID, roleID int64) (int64, error)
+		GetUserIDs(context.Context, int64) ([]int64, error)
+	}
+	RefreshTokens interface {
+		Create(ctx context.Context, token string, userID int64, familyID string, exp time.Duration) error
+		Rotate(ctx context.Context, token, newToken string, exp time.Duration) (*RefreshToken, error)
+		RevokeFamily(ctx context.Context, familyID string) error
+		RevokeAllForUser(ctx context.Context, userID int64) error
+	}
+	MFA interface {
+		Get(context.Context, int64) (*TOTP, error)
+		SetPendingSecret(ctx context.Context, userID int64, secret string) error
+		Enable(ctx context.Context, userID int64, step int64, recoveryCodes []string) error
+		Disable(context.Context, int64) error
+		UseStep(ctx context.Context, userID int64, step int64) error
+		UseRecoveryCode(ctx context.Context, userID int64, code string) error
+	}
+	APIKeys interface {
+		Create(ctx context.Context, key *APIKey, plainKey string) error
+		GetByUserID(context.Context, int64) ([]APIKey, error)
+		GetByKey(context.Context, string) (*APIKey, error)
+		Revoke(ctx context.Context, keyID, userID int64) error
+		RevokeAllForUser(context.Context, int64) error
+	}
+	RevokedTokens interface {
+		Revoke(ctx context.Context, jti string, exp time.Time) error
+		IsRevoked(ctx context.Context, jti string) (bool, error)
+		RevokeIssuedBefore(ctx context.Context, userID int64, t time.Time, ttl time.Duration) error
+		IssuedBefore(context.Context, int64) (time.Time, error)
+		RevokeFamily(ctx context.Context, familyID string, ttl time.Duration) error
+		IsFamilyRevoked(ctx context.Context, familyID string) (bool, error)
+		PurgeExpired(context.Context) (int64, error)
+	}
+	Sessions interface {
+		Create(ctx context.Context, session *Session, refreshToken string, exp time.Duration) error
+		GetActiveByUserID(context.Context, int64) ([]Session, error)
+		Revoke(ctx context.Context, sessionID string, userID int64) error
+		Touch(ctx context.Context, seen map[string]time.Time) error
+		PurgeEnded(ctx context.Context, seenBefore time.Time) (int64, error)
+	}
+	Audit interface {
+		Create(context.Context, []*AuditEvent) error
+		List(context.Context, AuditQuery) ([]AuditEvent, error)
 	}
 }
 
-func NewStorage(db *sql.DB) Storage {
+// NewStorage wires every store to db, new password hashes use hashParams and mfaSecretKey encrypts
+// the TOTP secrets
+func NewStorage(db *sql.DB, hashParams Argon2Params, mfaSecretKey string) Storage {
 	return Storage{
 		Posts:     &PostStore{db},
-		Users:     &UserStore{db},
+		Users:     &UserStore{db, newPasswordHasher(hashParams)},
 		Comments:  &CommentStore{db},
+		Reactions: &ReactionStore{db},
 		Followers: &FollowerStore{db},
 		Roles:     &RoleStore{db},
+
+		RefreshTokens: &RefreshTokenStore{db},
+		MFA:           &MFAStore{db, newSecretBox(mfaSecretKey)},
+		APIKeys:       &APIKeyStore{db},
+		RevokedTokens: &RevokedTokenStore{db},
+		Sessions:      &SessionStore{db},
+		Audit:         &AuditStore{db},
+		PostRevisions: &PostRevisionStore{db},
+		Media:         &MediaStore{db},
 	}
 }
 
@@ -67,3 +177,28 @@ func withTx(db *sql.DB, ctx context.Context, fn func(*sql.Tx) error) error {
 
 	return tx.Commit()
 }
+
+// execAffectingOne runs query and returns ErrNotFound when it didn't change a row
+func execAffectingOne(ctx context.Context, db *sql.DB, query string, args ...any) error {
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// hashToken is the same sha256 hashing we use for user_invitations, plaintext tokens never hit the database
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// scopes of the single use tokens kept in user_tokens
+const (
+	ScopeUnlock        = "unlock"
+	ScopePasswordReset = "password_reset"
+	ScopeMagicLink     = "magic_link"
+)
+
+func createUserToken(ctx context.Context, tx *sql.Tx, userID int64, scope, token string, exp time.Duration) error {
+	query := `INSERT INTO user_tokens (token, user_id, scope, expiry) VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, hashToken(token), userID, scope, time.Now().Add(exp))
+	return err
+}
+
+// getUserIDFromToken hashes the plaintext token from the request and looks it up like getUserFromInvitation
+func getUserIDFromToken(ctx context.Context, tx *sql.Tx, scope, token string) (int64, error) {
+	query := `SELECT user_id FROM user_tokens WHERE token = $1 AND scope = $2 AND expiry > $3`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var userID int64
+	err := tx.QueryRowContext(ctx, query, hashToken(token), scope, time.Now()).Scan(&userID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return 0, ErrNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+func deleteUserTokens(ctx context.Context, tx *sql.Tx, scope string, userID int64) error {
+	query := `DELETE FROM user_tokens WHERE scope = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, scope, userID)
+	return err
+}
+
+// deleteAllUserTokens invalidates every outstanding link of any scope
+func deleteAllUserTokens(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `DELETE FROM user_tokens WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	return err
+}
@@ -8,12 +8,13 @@ import (
 	"errors"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/lib/pq"
 )
 
 var (
 	ErrDuplicateEmail    = errors.New("a user with that email already exists")
 	ErrDuplicateUsername = errors.New("a user with that username already exists")
+	ErrPasswordMismatch  = errors.New("password does not match")
 )
 
 type User struct {
@@ -28,6 +29,16 @@ type User struct {
 	//ex 56 add RoleId and Role
 	RoleID int64 `json:"role_id"`
 	Role   Role  `json:"role"`
+	//the secret itself only lives in the MFA store, this flag is enough to know a second step is needed
+	MFAEnabled bool `json:"mfa_enabled"`
+	//failed logins are tracked per account, once the limit is hit the account is locked for a while
+	FailedLoginAttempts int        `json:"-"`
+	LockedUntil         *time.Time `json:"-"`
+}
+
+// IsLocked reports if the account is currently locked out because of failed logins
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
 }
 
 // ex 43 user registration Password type will have text which is pointer to string
@@ -35,23 +46,27 @@ type User struct {
 type password struct {
 	text *string
 	hash []byte
+	//set by UserStore.ComparePassword, the hash is bcrypt or has old argon2id parameters
+	outdated bool
 }
 
-// ex 43
-func (p *password) Set(text string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(text), bcrypt.DefaultCost)
-	if err != nil {
-		return err
-	}
-
+// ex 43, SetPlaintext only keeps the plaintext, the UserStore hashes it with its argon2id parameters
+// when it writes the user and drops the plaintext then
+func (p *password) SetPlaintext(text string) {
 	p.text = &text
-	p.hash = hash
+	p.hash = nil
+	p.outdated = false
+}
 
-	return nil
+// NeedsRehash is true when UserStore.ComparePassword found a bcrypt hash or argon2id parameters other than
+// the configured ones, the login replaces the hash while it has the plaintext at hand
+func (p *password) NeedsRehash() bool {
+	return p.outdated
 }
 
 type UserStore struct {
-	db *sql.DB
+	db        *sql.DB
+	passwords *passwordHasher
 }
 
 func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
@@ -61,6 +76,10 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 	($1, $2, $3, (SELECT id FROM roles WHERE name = $4)) RETURNING id, created_at
 	`
 
+	if err := s.passwords.hash(&user.Password); err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
@@ -108,10 +127,10 @@ func (s *UserStore) GetByID(ctx context.Context, userID int64) (*User, error) {
 	// `
 	//ex 56 Precedence middleware joining roles table to get roles all rows output of roles.*
 	query := `
-	SELECT users.id, username, email, password, created_at, roles.*
+	SELECT users.id, username, email, password, created_at, totp_enabled, failed_login_attempts, locked_until, roles.*
 	FROM users
 	JOIN roles ON (users.role_id = roles.id)
-	WHERE users.id = $1 AND is_active = true
+	WHERE users.id = $1 AND is_active = true AND deleted_at IS NULL
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
@@ -124,6 +143,9 @@ func (s *UserStore) GetByID(ctx context.Context, userID int64) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.CreatedAt,
+		&user.MFAEnabled,
+		&user.FailedLoginAttempts,
+		&user.LockedUntil,
 		//ex 56 returing row of roles for the user
 		&user.Role.ID,
 		&user.Role.Name,
@@ -163,14 +185,17 @@ func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, token strin
 }
 
 // ex 45 user activation
-func (s *UserStore) Activate(ctx context.Context, token string) error {
+// Activate returns the ID of the activated user
+func (s *UserStore) Activate(ctx context.Context, token string) (int64, error) {
+	var userID int64
 	//have token in param, need to check inside database if that token matches for that userID
-	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
 		//1. find the user that this token belongs to
 		user, err := s.getUserFromInvitation(ctx, tx, token)
 		if err != nil {
 			return err
 		}
+		userID = user.ID
 
 		//2. update the user
 		user.IsActive = true
@@ -185,6 +210,8 @@ func (s *UserStore) Activate(ctx context.Context, token string) error {
 		}
 		return nil
 	})
+
+	return userID, err
 }
 
 func (s *UserStore) getUserFromInvitation(ctx context.Context, tx *sql.Tx, token string) (*User, error) {
@@ -262,13 +289,15 @@ func (s *UserStore) deleteUserInvitations(ctx context.Context, tx *sql.Tx, userI
 }
 
 // ex 46 This function used for Deleting the user from users table and cleaning his invitation from user_ invitations
+// Users who never activated are gone right away (e.g. the registration rollback), everybody else is soft deleted
+// and signed out everywhere until Undelete or the retention job
 func (s *UserStore) Delete(ctx context.Context, userID int64) error {
 	return withTx(s.db, ctx, func(tx *sql.Tx) error {
-		if err := s.delete(ctx, tx, userID); err != nil {
+		if err := s.deleteUserInvitations(ctx, tx, userID); err != nil {
 			return err
 		}
 
-		if err := s.deleteUserInvitations(ctx, tx, userID); err != nil {
+		if err := s.delete(ctx, tx, userID); err != nil {
 			return err
 		}
 
@@ -278,22 +307,109 @@ func (s *UserStore) Delete(ctx context.Context, userID int64) error {
 
 // ex 46
 func (s *UserStore) delete(ctx context.Context, tx *sql.Tx, id int64) error {
-	query := `DELETE FROM user WHERE user_id = $1`
-
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	_, err := tx.ExecContext(ctx, query, id)
+	res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1 AND is_active = false`, id)
 	if err != nil {
 		return err
 	}
-	return nil
+	//never activated, there is nothing to keep
+	if rows, err := res.RowsAffected(); err != nil || rows > 0 {
+		return err
+	}
+
+	res, err = tx.ExecContext(ctx, `UPDATE users SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, id)
+	return err
+}
+
+// Undelete brings back a soft deleted user, they sign in again like after a logout everywhere
+func (s *UserStore) Undelete(ctx context.Context, userID int64) error {
+	query := `UPDATE users SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, userID)
+}
+
+// PurgeDeleted hard deletes users deleted before deletedBefore with their posts. Their comments are marked
+// deleted as of the user, the comment purge takes them once nobody replies to them anymore
+func (s *UserStore) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var purged int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		var ids []int64
+		query := `SELECT ARRAY(SELECT id FROM users WHERE deleted_at < $1 FOR UPDATE)`
+		if err := tx.QueryRowContext(ctx, query, deletedBefore).Scan(pq.Array(&ids)); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		var postIDs []int64
+		query = `
+			WITH deleted AS (
+				UPDATE posts SET deleted_at = COALESCE(deleted_at, NOW()) WHERE user_id = ANY($1) RETURNING id
+			)
+			SELECT ARRAY(SELECT id FROM deleted)
+		`
+		if err := tx.QueryRowContext(ctx, query, pq.Array(ids)).Scan(pq.Array(&postIDs)); err != nil {
+			return err
+		}
+		if err := purgePosts(ctx, tx, postIDs); err != nil {
+			return err
+		}
+
+		query = `
+			UPDATE comments c SET deleted_at = COALESCE(c.deleted_at, u.deleted_at)
+			FROM users u WHERE u.id = c.user_id AND u.id = ANY($1)
+		`
+		if _, err := tx.ExecContext(ctx, query, pq.Array(ids)); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ANY($1)`, pq.Array(ids))
+		if err != nil {
+			return err
+		}
+
+		purged, err = res.RowsAffected()
+		return err
+	})
+
+	return purged, err
+}
+
+// ComparePassword checks text against the password of user, ErrPasswordMismatch when they don't match.
+// user is nil for an unknown email, that still costs the same as a wrong password
+func (s *UserStore) ComparePassword(user *User, text string) error {
+	if user == nil {
+		return s.passwords.compare(&password{}, text)
+	}
+
+	return s.passwords.compare(&user.Password, text)
 }
 
 // ex 51 generating tokens
 func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
-	query := `SELECT id, username, email, password, created_at FROM users
-    			WHERE email = $1 AND is_active = true
+	query := `SELECT id, username, email, password, created_at, totp_enabled, failed_login_attempts, locked_until FROM users
+    			WHERE email = $1 AND is_active = true AND deleted_at IS NULL
 				`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -301,6 +417,7 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 	user := &User{}
 	err := s.db.QueryRowContext(ctx, query, email).Scan(
 		&user.ID, &user.Username, &user.Email, &user.Password.hash, &user.CreatedAt,
+		&user.MFAEnabled, &user.FailedLoginAttempts, &user.LockedUntil,
 	)
 
 	if err != nil {
@@ -314,3 +431,224 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 
 	return user, nil
 }
+
+// RecordFailedLogin bumps the failed login counter, when it reaches maxAttempts the account gets locked
+// for lockout and the counter starts again. locked is only true for the attempt which caused the lock
+func (s *UserStore) RecordFailedLogin(ctx context.Context, userID int64, maxAttempts int, lockout time.Duration) (bool, error) {
+	query := `
+	UPDATE users
+	SET
+		failed_login_attempts = CASE WHEN failed_login_attempts + 1 >= $2 THEN 0 ELSE failed_login_attempts + 1 END,
+		locked_until = CASE WHEN failed_login_attempts + 1 >= $2 THEN $3 ELSE locked_until END
+	WHERE id = $1
+	RETURNING failed_login_attempts
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var attempts int
+	err := s.db.QueryRowContext(ctx, query, userID, maxAttempts, time.Now().Add(lockout)).Scan(&attempts)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return false, ErrNotFound
+		default:
+			return false, err
+		}
+	}
+
+	//the counter only goes back to zero when the lock kicked in
+	return attempts == 0, nil
+}
+
+// ResetFailedLogins clears the counter and any lock after a successful login
+func (s *UserStore) ResetFailedLogins(ctx context.Context, userID int64) error {
+	query := `UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// CreateToken stores a single use token for an existing user, e.g. the link in the unlock email
+func (s *UserStore) CreateToken(ctx context.Context, userID int64, scope, token string, exp time.Duration) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		return createUserToken(ctx, tx, userID, scope, token, exp)
+	})
+}
+
+// Unlock lifts a lockout using the token from the unlock email, it returns the ID of the unlocked user
+func (s *UserStore) Unlock(ctx context.Context, token string) (int64, error) {
+	var userID int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		var err error
+		userID, err = getUserIDFromToken(ctx, tx, ScopeUnlock, token)
+		if err != nil {
+			return err
+		}
+
+		query := `UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id = $1`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		_, err = tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+
+		return deleteUserTokens(ctx, tx, ScopeUnlock, userID)
+	})
+
+	return userID, err
+}
+
+// RedeemMagicLink returns the owner of a login link, the link and every other one of the user stop working
+func (s *UserStore) RedeemMagicLink(ctx context.Context, token string) (int64, error) {
+	var userID int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		var err error
+		userID, err = getUserIDFromToken(ctx, tx, ScopeMagicLink, token)
+		if err != nil {
+			return err
+		}
+
+		return deleteUserTokens(ctx, tx, ScopeMagicLink, userID)
+	})
+
+	return userID, err
+}
+
+// ResetPassword stores the new password of user (set with Password.SetPlaintext) for the owner of the reset token.
+// Every other emailed link, every refresh token family and every API key of the user stop working afterwards
+func (s *UserStore) ResetPassword(ctx context.Context, token string, user *User) error {
+	if err := s.passwords.hash(&user.Password); err != nil {
+		return err
+	}
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		userID, err := getUserIDFromToken(ctx, tx, ScopePasswordReset, token)
+		if err != nil {
+			return err
+		}
+		user.ID = userID
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		//a reset also lifts a lockout, proving access to the inbox is the same as the unlock link
+		query := `UPDATE users SET password = $1, failed_login_attempts = 0, locked_until = NULL WHERE id = $2`
+		_, err = tx.ExecContext(ctx, query, user.Password.hash, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := deleteAllUserTokens(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		//a pending email change could be the attacker's, the old inbox was told to reset the password
+		if err := deleteEmailChangeRequests(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		//logs out every device, whoever knew the old password shouldn't keep a session
+		query = `UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+		_, err = tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+
+		//nor a key they created while they had the account
+		return revokeAllAPIKeys(ctx, tx, userID)
+	})
+}
+
+// RotateInvitation replaces the invitations of the inactive user with this email by a new one, for a lost
+// welcome email. ErrNotFound if there is no such user or it is already active
+func (s *UserStore) RotateInvitation(ctx context.Context, email, token string, invitationExp time.Duration) (*User, error) {
+	var user *User
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `SELECT id, username, email, created_at FROM users WHERE email = $1 AND is_active = false FOR UPDATE`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		user = &User{}
+		err := tx.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		//the link in the lost email must not keep working next to the new one
+		if err := s.deleteUserInvitations(ctx, tx, user.ID); err != nil {
+			return err
+		}
+
+		return s.createUserInvitation(ctx, tx, token, invitationExp, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// PurgeExpiredInvitations deletes invitations which can't be accepted anymore
+func (s *UserStore) PurgeExpiredInvitations(ctx context.Context) (int64, error) {
+	query := `DELETE FROM user_invitations WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// PurgeUnactivated deletes users created before createdBefore who never activated, which frees their username
+// and email again. Users with an invitation that can still be accepted (e.g. just resent) are kept
+func (s *UserStore) PurgeUnactivated(ctx context.Context, createdBefore time.Time) (int64, error) {
+	query := `
+	DELETE FROM users u
+	WHERE u.is_active = false AND u.created_at < $1
+	AND NOT EXISTS (SELECT 1 FROM user_invitations ui WHERE ui.user_id = u.id AND ui.expiry > NOW())
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// UpdatePassword hashes and stores user.Password (set with Password.SetPlaintext), used to upgrade outdated hashes on login
+func (s *UserStore) UpdatePassword(ctx context.Context, user *User) error {
+	if err := s.passwords.hash(&user.Password); err != nil {
+		return err
+	}
+
+	query := `UPDATE users SET password = $1 WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, user.Password.hash, user.ID)
+	return err
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"sync"
 )
 
@@ -13,7 +14,24 @@ type UpdatePostPayload struct {
 	Content *string `json:"content"`
 }
 
-func updatePost(postID int, p UpdatePostPayload, wg *sync.WaitGroup) {
+// getETag fetches the version of the post both users start editing from
+func getETag(postID int) (string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:8080/v1/posts/%d", postID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("TOKEN"))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	return resp.Header.Get("ETag"), nil
+}
+
+func updatePost(postID int, etag string, p UpdatePostPayload, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	// Construct the URL for the update endpoint
@@ -30,6 +48,9 @@ func updatePost(postID int, p UpdatePostPayload, wg *sync.WaitGroup) {
 
 	// Set headers as needed, for example:
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("TOKEN"))
+	// only the first update matches, the other one gets 412 Precondition Failed
+	req.Header.Set("If-Match", etag)
 
 	// Send the request
 	client := &http.Client{}
@@ -49,12 +70,18 @@ func main() {
 	// Assuming the post ID to update is 1
 	postID := 13
 
+	etag, err := getETag(postID)
+	if err != nil {
+		fmt.Println("Error fetching post:", err)
+		return
+	}
+
 	// Simulate User A and User B updating the same post concurrently
 	wg.Add(2)
 	content := "NEW CONTENT FROM USER B"
 	title := "NEW TITLE FROM USER A"
 
-	go updatePost(postID, UpdatePostPayload{Title: &title}, &wg)
-	go updatePost(postID, UpdatePostPayload{Content: &content}, &wg)
+	go updatePost(postID, etag, UpdatePostPayload{Title: &title}, &wg)
+	go updatePost(postID, etag, UpdatePostPayload{Content: &content}, &wg)
 	wg.Wait()
 }